@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"expvar"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
@@ -10,6 +14,7 @@ import (
 	"syscall"
 	"time"
 
+	"golang-grpc/internal/auth"
 	"golang-grpc/internal/config"
 	"golang-grpc/internal/service"
 	grpctransport "golang-grpc/internal/transport/grpc"
@@ -19,21 +24,80 @@ import (
 )
 
 func main() {
+	preload := flag.String("preload", "", "load users from this snapshot file before serving")
+	preloadMode := flag.String("preload-mode", string(service.RestoreReplace), "how to apply the -preload snapshot: replace or merge")
+	flag.Parse()
+
 	cfg := config.Load()
 
-	store := user.NewStore()
-	userService := service.NewUserService(store)
+	store, err := openStore(cfg.Store)
+	if err != nil {
+		log.Fatalf("failed to open %s store: %v", cfg.Store.Backend, err)
+	}
+	expvar.Publish("store_reaper", expvar.Func(func() any { return store.ReapStats() }))
+
+	userService := service.NewUserService(store, service.Options{
+		Limits: service.Limits{
+			MaxAvatarBytes: cfg.MaxAvatarBytes,
+			MaxTags:        cfg.MaxTags,
+			MaxFieldLength: cfg.MaxFieldLength,
+			MaxBatchSize:   cfg.MaxBatchSize,
+			MaxPageSize:    cfg.MaxPageSize,
+		},
+		IdempotencyWindow:  cfg.IdempotencyWindow,
+		IdempotencyMaxKeys: cfg.IdempotencyMaxKeys,
+	})
+
+	if *preload != "" {
+		result, err := userService.ImportSnapshot(context.Background(), *preload, "", service.RestoreMode(*preloadMode))
+		if err != nil {
+			log.Fatalf("failed to preload %s: %v", *preload, err)
+		}
+		log.Printf("preloaded %d users from %s (%d rejected)", result.Accepted, *preload, result.Rejected)
+		for _, e := range result.Errors {
+			log.Printf("preload: user %d: %v", e.Index, e.Err)
+		}
+	}
+
+	var admin *service.Admin
+	if cfg.Admin.Enabled {
+		admin = service.NewAdmin(userService, service.AdminOptions{SnapshotDir: cfg.Admin.SnapshotDir})
+		log.Printf("admin endpoints enabled (snapshots in %s)", cfg.Admin.SnapshotDir)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enabled() {
+		var err error
+		tlsConfig, err = auth.ServerTLSConfig(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatalf("failed to configure TLS: %v", err)
+		}
+		log.Printf("TLS enabled (mutual=%t)", cfg.TLS.ClientCAFile != "")
+	}
 
-	grpcServer := grpctransport.NewServer(userService)
+	grpcOpts := grpctransport.Options{
+		TLSConfig:      tlsConfig,
+		MaxRecvMsgSize: cfg.MaxRequestBytes,
+		MaxSendMsgSize: cfg.MaxResponseBytes,
+	}
+	if admin != nil {
+		grpcOpts.Admin = admin
+	}
+	grpcServer := grpctransport.NewServer(userService, grpcOpts)
 	grpcListener, err := net.Listen("tcp", cfg.GRPCAddr)
 	if err != nil {
 		log.Fatalf("failed to listen on %s: %v", cfg.GRPCAddr, err)
 	}
 
-	router := httptransport.NewRouter(userService)
+	router := httptransport.NewRouter(userService, httptransport.Options{
+		MaxRequestBytes: int64(cfg.MaxRequestBytes),
+		CacheMaxAge:     cfg.CacheMaxAge,
+		Admin:           admin,
+	})
 	httpServer := &http.Server{
-		Addr:    cfg.HTTPAddr,
-		Handler: router,
+		Addr:      cfg.HTTPAddr,
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -49,8 +113,15 @@ func main() {
 	}()
 
 	go func() {
-		log.Printf("HTTP server listening on http://%s", cfg.HTTPAddr)
-		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("HTTP server listening on https://%s", cfg.HTTPAddr)
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("HTTP server listening on http://%s", cfg.HTTPAddr)
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errCh <- err
 		}
 	}()
@@ -68,6 +139,77 @@ func main() {
 	stop()
 
 	shutdown(graceCtx, grpcServer, httpServer)
+	// Closing the store stops its reaper before the data is released.
+	if err := store.Close(); err != nil {
+		log.Printf("failed to close store: %v", err)
+	}
+}
+
+func openStore(cfg config.StoreConfig) (user.Repository, error) {
+	opts := user.Options{
+		Revisions:    cfg.Revisions,
+		ReapInterval: cfg.ReapInterval,
+		Capacity: user.Capacity{
+			MaxUsers: cfg.MaxUsers,
+			MaxBytes: cfg.MaxBytes,
+			Eviction: cfg.Eviction,
+		},
+	}
+	bounded := cfg.MaxUsers > 0 || cfg.MaxBytes > 0
+	if bounded && cfg.Backend != config.StoreMemory {
+		return nil, fmt.Errorf("STORE_MAX_USERS and STORE_MAX_BYTES are only supported by the %s backend", config.StoreMemory)
+	}
+	switch cfg.Eviction {
+	case user.EvictReject, user.EvictLRU:
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", cfg.Eviction)
+	}
+
+	switch cfg.Backend {
+	case config.StoreMemory:
+		var (
+			store *user.Store
+			err   error
+		)
+		if cfg.WALDir == "" {
+			store = user.NewStore(opts)
+		} else {
+			log.Printf("logging users to %s (fsync=%s)", cfg.WALDir, cfg.WALFsync)
+			store, err = user.OpenDurable(cfg.WALDir, opts, user.DurabilityOptions{
+				Fsync:            cfg.WALFsync,
+				FsyncInterval:    cfg.WALFsyncInterval,
+				SnapshotInterval: cfg.SnapshotInterval,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		expvar.Publish("store_locks", expvar.Func(func() any {
+			return map[string]any{"total": store.LockStats()}
+		}))
+		if bounded {
+			log.Printf("bounding users to max_users=%d max_bytes=%d (eviction=%s)", cfg.MaxUsers, cfg.MaxBytes, cfg.Eviction)
+			expvar.Publish("store_capacity", expvar.Func(func() any { return store.CapacityStats() }))
+		}
+		return store, nil
+	case config.StoreSharded:
+		log.Printf("sharding users over %d shards", cfg.Shards)
+		store := user.NewShardedStore(cfg.Shards, opts)
+		expvar.Publish("store_locks", expvar.Func(func() any {
+			shards, emails := store.LockStats()
+			var total user.LockStats
+			for _, st := range shards {
+				total = total.Add(st)
+			}
+			return map[string]any{"total": total, "shards": shards, "emails": emails}
+		}))
+		return store, nil
+	case config.StoreBolt:
+		log.Printf("storing users in %s", cfg.Path)
+		return user.OpenBolt(cfg.Path, opts)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+	}
 }
 
 func shutdown(ctx context.Context, grpcServer *grpc.Server, httpServer *http.Server) {
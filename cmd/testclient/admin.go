@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// serverPreparer resets the server's store before every measured phase when
+// cfg.Reset is set, and seeds it with cfg.SeedUsers users, so each transport
+// starts from identical state. Admin endpoints are off by default; if the server does
+// not serve them, the client says so once and measures against the store as
+// it is.
+type serverPreparer struct {
+	client   *http.Client
+	baseURL  string
+	reset    bool
+	seed     int
+	disabled bool
+}
+
+func newServerPreparer(cfg benchConfig, tlsConfig *tls.Config) *serverPreparer {
+	return &serverPreparer{
+		client:  &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		baseURL: cfg.HTTPBaseURL,
+		reset:   cfg.Reset,
+		seed:    cfg.SeedUsers,
+	}
+}
+
+func (p *serverPreparer) prepare(phase string) error {
+	if !p.reset || p.disabled {
+		return nil
+	}
+
+	var reset struct {
+		Removed int `json:"removed"`
+	}
+	status, err := p.post("/admin/store:reset", nil, &reset)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		fmt.Println("Note: server admin endpoints are disabled (ADMIN_ENABLED); phases share the store as is")
+		p.disabled = true
+		return nil
+	}
+	if p.seed == 0 {
+		fmt.Printf("Prepared %s: removed %d users\n", phase, reset.Removed)
+		return nil
+	}
+
+	var seeded struct {
+		Created int `json:"created"`
+		Failed  int `json:"failed"`
+	}
+	request := map[string]any{
+		"count":        p.seed,
+		"tags":         payloadTagCount,
+		"avatar_bytes": payloadAvatarBytes,
+		"bio_bytes":    len(buildBio("seed", 0, 0)),
+	}
+	if _, err := p.post("/admin/store:seed", request, &seeded); err != nil {
+		return err
+	}
+	fmt.Printf("Prepared %s: removed %d users, seeded %d\n", phase, reset.Removed, seeded.Created)
+	return nil
+}
+
+// post sends body as JSON and decodes a 200 response into out. A 404 is
+// returned as a status rather than an error.
+func (p *serverPreparer) post(path string, body, out any) (int, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := p.client.Post(p.baseURL+path, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+	case http.StatusNotFound:
+		return resp.StatusCode, nil
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("%s: unexpected status %d: %s", path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// The avatar scenario compares large binary transfers: chunked gRPC
+// streaming against raw octet-stream HTTP bodies.
+var avatarOperations = []string{"upload", "download"}
+
+func buildBlob(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte((i*31 + i/4096) % 251)
+	}
+	return data
+}
+
+// -------------------- HTTP --------------------
+
+func measureHTTPAvatar(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	client, counter := newHTTPClient(cfg, tlsConfig)
+	usersURL := cfg.HTTPBaseURL + "/users"
+	blob := buildBlob(cfg.AvatarBytes)
+
+	for i := 0; i < cfg.Warmup; i++ {
+		payload := makeUserPayload("warm-http-avatar", httpEmailDomain, i, createDataSalt)
+		payload.Avatar = nil
+		if u, err := httpCreateUser(client, usersURL, payload); err == nil {
+			_ = httpPutAvatar(client, usersURL+"/"+u.ID+"/avatar", blob)
+			_ = httpDeleteUser(client, usersURL, u.ID)
+		}
+	}
+
+	return runWorkers(cfg, avatarOperations, counter, func(a, b int, out chan<- opResult) {
+		runHTTPAvatarWorker(client, usersURL, blob, a, b, out)
+	}), nil
+}
+
+func runHTTPAvatarWorker(client *http.Client, usersURL string, blob []byte, idxStart, idxEnd int, out chan<- opResult) {
+	for i := idxStart; i < idxEnd; i++ {
+		payload := makeUserPayload("http-avatar", httpEmailDomain, i, createDataSalt)
+		payload.Avatar = nil
+		created, err := httpCreateUser(client, usersURL, payload)
+		if err != nil {
+			out <- opResult{op: "upload", err: err}
+			continue
+		}
+		avatarURL := usersURL + "/" + created.ID + "/avatar"
+
+		t0 := time.Now()
+		err = httpPutAvatar(client, avatarURL, blob)
+		out <- opResult{"upload", time.Since(t0), err}
+
+		t0 = time.Now()
+		err = httpGetAvatar(client, avatarURL, len(blob))
+		out <- opResult{"download", time.Since(t0), err}
+
+		_ = httpDeleteUser(client, usersURL, created.ID)
+	}
+}
+
+func httpPutAvatar(client *http.Client, avatarURL string, blob []byte) error {
+	req, err := http.NewRequest(http.MethodPut, avatarURL, bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func httpGetAvatar(client *http.Client, avatarURL string, want int) error {
+	resp, err := client.Get(avatarURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return err
+	}
+	if n != int64(want) {
+		return fmt.Errorf("downloaded %d bytes, want %d", n, want)
+	}
+	return nil
+}
+
+// -------------------- gRPC --------------------
+
+func measureGRPCAvatar(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	conn, counter, err := dialGRPC(cfg, tlsConfig)
+	if err != nil {
+		return batchResult{}, err
+	}
+	defer conn.Close()
+
+	client := userpb.NewUserServiceClient(conn)
+	blob := buildBlob(cfg.AvatarBytes)
+
+	for i := 0; i < cfg.Warmup; i++ {
+		payload := makeUserPayload("warm-grpc-avatar", grpcEmailDomain, i, createDataSalt)
+		payload.Avatar = nil
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.RPCTimeout)
+		if u, err := client.CreateUser(ctx, payload.toCreateRequest()); err == nil {
+			_ = grpcUploadAvatar(ctx, client, u.GetUser().GetId(), blob, cfg.AvatarChunkBytes)
+			_, _ = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: u.GetUser().GetId()})
+		}
+		cancel()
+	}
+
+	return runWorkers(cfg, avatarOperations, counter, func(a, b int, out chan<- opResult) {
+		runGRPCAvatarWorker(client, blob, cfg.AvatarChunkBytes, a, b, cfg.RPCTimeout, out)
+	}), nil
+}
+
+func runGRPCAvatarWorker(client userpb.UserServiceClient, blob []byte, chunk, idxStart, idxEnd int, to time.Duration, out chan<- opResult) {
+	for i := idxStart; i < idxEnd; i++ {
+		payload := makeUserPayload("grpc-avatar", grpcEmailDomain, i, createDataSalt)
+		payload.Avatar = nil
+		ctx, cancel := context.WithTimeout(context.Background(), to)
+		created, err := client.CreateUser(ctx, payload.toCreateRequest())
+		cancel()
+		if err != nil {
+			out <- opResult{op: "upload", err: err}
+			continue
+		}
+		id := created.GetUser().GetId()
+
+		t0 := time.Now()
+		ctx, cancel = context.WithTimeout(context.Background(), to)
+		err = grpcUploadAvatar(ctx, client, id, blob, chunk)
+		cancel()
+		out <- opResult{"upload", time.Since(t0), err}
+
+		t0 = time.Now()
+		ctx, cancel = context.WithTimeout(context.Background(), to)
+		err = grpcDownloadAvatar(ctx, client, id, len(blob))
+		cancel()
+		out <- opResult{"download", time.Since(t0), err}
+
+		ctx, cancel = context.WithTimeout(context.Background(), to)
+		_, _ = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: id})
+		cancel()
+	}
+}
+
+func grpcUploadAvatar(ctx context.Context, client userpb.UserServiceClient, id string, blob []byte, chunk int) error {
+	stream, err := client.UploadAvatar(ctx)
+	if err != nil {
+		return err
+	}
+	for pos := 0; pos < len(blob) || pos == 0; pos += chunk {
+		end := min(pos+chunk, len(blob))
+		if err := stream.Send(&userpb.UploadAvatarRequest{Id: id, Chunk: blob[pos:end]}); err != nil {
+			return err
+		}
+		id = ""
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func grpcDownloadAvatar(ctx context.Context, client userpb.UserServiceClient, id string, want int) error {
+	stream, err := client.DownloadAvatar(ctx, &userpb.DownloadAvatarRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	n := 0
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n += len(msg.GetChunk())
+	}
+	if n != want {
+		return fmt.Errorf("downloaded %d bytes, want %d", n, want)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// The batch scenario sends BENCH_BATCH_SIZE users per request, comparing
+// JSON arrays against repeated protobuf messages. Each iteration is one
+// batch create, get and delete.
+var batchOperations = []string{"create", "get", "delete"}
+
+type wireBatchResult struct {
+	ID     string    `json:"id"`
+	Status int       `json:"status"`
+	Error  string    `json:"error"`
+	User   *wireUser `json:"user"`
+}
+
+func makeBatchPayload(prefix, domain string, idx, size int) []wireUser {
+	users := make([]wireUser, size)
+	for i := range users {
+		users[i] = makeUserPayload(prefix, domain, idx*size+i, createDataSalt)
+	}
+	return users
+}
+
+// -------------------- HTTP --------------------
+
+func measureHTTPBatchOps(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	client, counter := newHTTPClient(cfg, tlsConfig)
+	usersURL := cfg.HTTPBaseURL + "/users"
+
+	for i := 0; i < cfg.Warmup; i++ {
+		created, err := httpBatch(client, usersURL+":batchCreate", map[string]any{"users": makeBatchPayload("warm-http-batch", httpEmailDomain, i, cfg.BatchSize)}, http.StatusCreated)
+		if err == nil {
+			_, _ = httpBatch(client, usersURL+":batchDelete", map[string]any{"ids": batchIDs(created)}, http.StatusNoContent)
+		}
+	}
+
+	return runWorkers(cfg, batchOperations, counter, func(a, b int, out chan<- opResult) {
+		runHTTPBatchWorker(client, usersURL, cfg.BatchSize, a, b, out)
+	}), nil
+}
+
+func runHTTPBatchWorker(client *http.Client, usersURL string, size, idxStart, idxEnd int, out chan<- opResult) {
+	for i := idxStart; i < idxEnd; i++ {
+		users := makeBatchPayload("http-batch", httpEmailDomain, i, size)
+
+		t0 := time.Now()
+		created, err := httpBatch(client, usersURL+":batchCreate", map[string]any{"users": users}, http.StatusCreated)
+		if err != nil {
+			out <- opResult{op: "create", err: err}
+			continue
+		}
+		out <- opResult{"create", time.Since(t0), nil}
+		ids := batchIDs(created)
+
+		t0 = time.Now()
+		_, err = httpBatch(client, usersURL+":batchGet", map[string]any{"ids": ids}, http.StatusOK)
+		out <- opResult{"get", time.Since(t0), err}
+
+		t0 = time.Now()
+		_, err = httpBatch(client, usersURL+":batchDelete", map[string]any{"ids": ids}, http.StatusNoContent)
+		out <- opResult{"delete", time.Since(t0), err}
+	}
+}
+
+// httpBatch posts a batch request and fails if any item did not end with
+// the expected per-item status.
+func httpBatch(client *http.Client, url string, payload any, itemStatus int) ([]wireBatchResult, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var decoded struct {
+		Results []wireBatchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, err
+	}
+	for _, r := range decoded.Results {
+		if r.Status != itemStatus {
+			return nil, fmt.Errorf("item %s: status %d: %s", r.ID, r.Status, r.Error)
+		}
+	}
+	return decoded.Results, nil
+}
+
+func batchIDs(results []wireBatchResult) []string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+// -------------------- gRPC --------------------
+
+func measureGRPCBatchOps(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	conn, counter, err := dialGRPC(cfg, tlsConfig)
+	if err != nil {
+		return batchResult{}, err
+	}
+	defer conn.Close()
+
+	client := userpb.NewUserServiceClient(conn)
+
+	for i := 0; i < cfg.Warmup; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.RPCTimeout)
+		req := toBatchCreateRequest(makeBatchPayload("warm-grpc-batch", grpcEmailDomain, i, cfg.BatchSize))
+		if resp, err := client.BatchCreateUsers(ctx, req); err == nil {
+			_, _ = client.BatchDeleteUsers(ctx, &userpb.BatchDeleteUsersRequest{Ids: grpcBatchIDs(resp)})
+		}
+		cancel()
+	}
+
+	return runWorkers(cfg, batchOperations, counter, func(a, b int, out chan<- opResult) {
+		runGRPCBatchWorker(client, cfg.BatchSize, a, b, cfg.RPCTimeout, out)
+	}), nil
+}
+
+func runGRPCBatchWorker(client userpb.UserServiceClient, size, idxStart, idxEnd int, to time.Duration, out chan<- opResult) {
+	for i := idxStart; i < idxEnd; i++ {
+		req := toBatchCreateRequest(makeBatchPayload("grpc-batch", grpcEmailDomain, i, size))
+
+		t0 := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), to)
+		created, err := client.BatchCreateUsers(ctx, req)
+		cancel()
+		if err == nil {
+			err = checkBatchResults(created)
+		}
+		if err != nil {
+			out <- opResult{op: "create", err: err}
+			continue
+		}
+		out <- opResult{"create", time.Since(t0), nil}
+		ids := grpcBatchIDs(created)
+
+		t0 = time.Now()
+		ctx, cancel = context.WithTimeout(context.Background(), to)
+		resp, err := client.BatchGetUsers(ctx, &userpb.BatchGetUsersRequest{Ids: ids})
+		cancel()
+		if err == nil {
+			err = checkBatchResults(resp)
+		}
+		out <- opResult{"get", time.Since(t0), err}
+
+		t0 = time.Now()
+		ctx, cancel = context.WithTimeout(context.Background(), to)
+		resp, err = client.BatchDeleteUsers(ctx, &userpb.BatchDeleteUsersRequest{Ids: ids})
+		cancel()
+		if err == nil {
+			err = checkBatchResults(resp)
+		}
+		out <- opResult{"delete", time.Since(t0), err}
+	}
+}
+
+func toBatchCreateRequest(users []wireUser) *userpb.BatchCreateUsersRequest {
+	req := &userpb.BatchCreateUsersRequest{Users: make([]*userpb.CreateUserRequest, len(users))}
+	for i, u := range users {
+		req.Users[i] = u.toCreateRequest()
+	}
+	return req
+}
+
+func checkBatchResults(resp *userpb.BatchUsersResponse) error {
+	for _, r := range resp.GetResults() {
+		if code := codes.Code(r.GetCode()); code != codes.OK {
+			return fmt.Errorf("item %s: %s: %s", r.GetId(), code, r.GetMessage())
+		}
+	}
+	return nil
+}
+
+func grpcBatchIDs(resp *userpb.BatchUsersResponse) []string {
+	ids := make([]string, len(resp.GetResults()))
+	for i, r := range resp.GetResults() {
+		ids[i] = r.GetId()
+	}
+	return ids
+}
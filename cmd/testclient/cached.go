@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// The cached scenario creates one user per worker and reads them over and
+// over, once unconditionally ("get") and once presenting the version the
+// client already holds ("cached"). Nothing changes in between, so every
+// cached read is answered with HTTP 304 or a gRPC not_modified response.
+var cachedOperations = []string{"get", "cached"}
+
+// -------------------- HTTP --------------------
+
+func measureHTTPCached(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	client, counter := newHTTPClient(cfg, tlsConfig)
+	usersURL := cfg.HTTPBaseURL + "/users"
+
+	ids := make([]string, 0, cfg.Concurrency)
+	defer func() {
+		for _, id := range ids {
+			_ = httpDeleteUser(client, usersURL, id)
+		}
+	}()
+	etags := make([]string, 0, cfg.Concurrency)
+	for i := 0; i < cfg.Concurrency; i++ {
+		u, err := httpCreateUser(client, usersURL, makeUserPayload("http-cached", httpEmailDomain, i, createDataSalt))
+		if err != nil {
+			return batchResult{}, fmt.Errorf("seeding: %w", err)
+		}
+		ids = append(ids, u.ID)
+		etag, _, err := httpConditionalGet(client, usersURL, u.ID, "")
+		if err != nil {
+			return batchResult{}, fmt.Errorf("seeding: %w", err)
+		}
+		etags = append(etags, etag)
+	}
+
+	for i := 0; i < cfg.Warmup; i++ {
+		_, _, _ = httpConditionalGet(client, usersURL, ids[i%len(ids)], etags[i%len(ids)])
+	}
+
+	return runWorkers(cfg, cachedOperations, counter, func(a, b int, out chan<- opResult) {
+		for i := a; i < b; i++ {
+			id, etag := ids[i%len(ids)], etags[i%len(ids)]
+
+			t0 := time.Now()
+			_, _, err := httpConditionalGet(client, usersURL, id, "")
+			out <- opResult{"get", time.Since(t0), err}
+
+			t0 = time.Now()
+			_, status, err := httpConditionalGet(client, usersURL, id, etag)
+			if err == nil && status != http.StatusNotModified {
+				err = fmt.Errorf("unexpected status %d", status)
+			}
+			out <- opResult{"cached", time.Since(t0), err}
+		}
+	}), nil
+}
+
+// httpConditionalGet reads a user, sending If-None-Match when etag is set,
+// and returns the response ETag and status. The body is drained so the
+// connection can be reused.
+func httpConditionalGet(client *http.Client, usersURL, id, etag string) (string, int, error) {
+	req, err := http.NewRequest(http.MethodGet, usersURL+"/"+id, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		return "", resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.Header.Get("ETag"), resp.StatusCode, nil
+}
+
+// -------------------- gRPC --------------------
+
+func measureGRPCCached(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	conn, counter, err := dialGRPC(cfg, tlsConfig)
+	if err != nil {
+		return batchResult{}, err
+	}
+	defer conn.Close()
+
+	client := userpb.NewUserServiceClient(conn)
+	call := func(fn func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.RPCTimeout)
+		defer cancel()
+		return fn(ctx)
+	}
+
+	users := make([]*userpb.User, 0, cfg.Concurrency)
+	defer func() {
+		for _, u := range users {
+			_ = call(func(ctx context.Context) error {
+				_, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: u.GetId()})
+				return err
+			})
+		}
+	}()
+	for i := 0; i < cfg.Concurrency; i++ {
+		payload := makeUserPayload("grpc-cached", grpcEmailDomain, i, createDataSalt)
+		err := call(func(ctx context.Context) error {
+			resp, err := client.CreateUser(ctx, payload.toCreateRequest())
+			if err == nil {
+				users = append(users, resp.GetUser())
+			}
+			return err
+		})
+		if err != nil {
+			return batchResult{}, fmt.Errorf("seeding: %w", err)
+		}
+	}
+
+	get := func(u *userpb.User, since int64) (*userpb.UserResponse, error) {
+		var resp *userpb.UserResponse
+		err := call(func(ctx context.Context) error {
+			var err error
+			resp, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: u.GetId(), IfChangedSinceVersion: since})
+			return err
+		})
+		return resp, err
+	}
+
+	for i := 0; i < cfg.Warmup; i++ {
+		u := users[i%len(users)]
+		_, _ = get(u, u.GetVersion())
+	}
+
+	return runWorkers(cfg, cachedOperations, counter, func(a, b int, out chan<- opResult) {
+		for i := a; i < b; i++ {
+			u := users[i%len(users)]
+
+			t0 := time.Now()
+			_, err := get(u, 0)
+			out <- opResult{"get", time.Since(t0), err}
+
+			t0 = time.Now()
+			resp, err := get(u, u.GetVersion())
+			if err == nil && !resp.GetNotModified() {
+				err = fmt.Errorf("user %s unexpectedly modified", u.GetId())
+			}
+			out <- opResult{"cached", time.Since(t0), err}
+		}
+	}), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// The list scenario pre-seeds BENCH_LIST_SEED users and has every worker
+// page through them BENCH_PAGE_SIZE at a time, wrapping around at the end.
+var listOperations = []string{"list"}
+
+// seedChunk keeps each seeding batch under the default request size limit.
+const seedChunk = 200
+
+// -------------------- HTTP --------------------
+
+func measureHTTPList(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	client, counter := newHTTPClient(cfg, tlsConfig)
+	usersURL := cfg.HTTPBaseURL + "/users"
+
+	ids, err := httpSeed(client, usersURL, cfg.ListSeed)
+	defer httpUnseed(client, usersURL, ids)
+	if err != nil {
+		return batchResult{}, fmt.Errorf("seeding: %w", err)
+	}
+
+	for i := 0; i < cfg.Warmup; i++ {
+		_, _ = httpListPage(client, usersURL, cfg.PageSize, "")
+	}
+
+	return runWorkers(cfg, listOperations, counter, func(a, b int, out chan<- opResult) {
+		token := ""
+		for i := a; i < b; i++ {
+			t0 := time.Now()
+			next, err := httpListPage(client, usersURL, cfg.PageSize, token)
+			out <- opResult{"list", time.Since(t0), err}
+			token = next
+		}
+	}), nil
+}
+
+func httpListPage(client *http.Client, usersURL string, size int, token string) (string, error) {
+	query := url.Values{"page_size": {strconv.Itoa(size)}}
+	if token != "" {
+		query.Set("page_token", token)
+	}
+	resp, err := client.Get(usersURL + "?" + query.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var page struct {
+		Users         []wireUser `json:"users"`
+		NextPageToken string     `json:"next_page_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return "", err
+	}
+	return page.NextPageToken, nil
+}
+
+func httpSeed(client *http.Client, usersURL string, n int) ([]string, error) {
+	var ids []string
+	for start := 0; start < n; start += seedChunk {
+		users := make([]wireUser, 0, seedChunk)
+		for i := start; i < min(start+seedChunk, n); i++ {
+			users = append(users, makeUserPayload("http-seed", httpEmailDomain, i, createDataSalt))
+		}
+		created, err := httpBatch(client, usersURL+":batchCreate", map[string]any{"users": users}, http.StatusCreated)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, batchIDs(created)...)
+	}
+	return ids, nil
+}
+
+func httpUnseed(client *http.Client, usersURL string, ids []string) {
+	for start := 0; start < len(ids); start += seedChunk {
+		_, _ = httpBatch(client, usersURL+":batchDelete", map[string]any{"ids": ids[start:min(start+seedChunk, len(ids))]}, http.StatusNoContent)
+	}
+}
+
+// -------------------- gRPC --------------------
+
+func measureGRPCList(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	conn, counter, err := dialGRPC(cfg, tlsConfig)
+	if err != nil {
+		return batchResult{}, err
+	}
+	defer conn.Close()
+
+	client := userpb.NewUserServiceClient(conn)
+
+	ids, err := grpcSeed(client, cfg.ListSeed, cfg.RPCTimeout)
+	defer grpcUnseed(client, ids, cfg.RPCTimeout)
+	if err != nil {
+		return batchResult{}, fmt.Errorf("seeding: %w", err)
+	}
+
+	for i := 0; i < cfg.Warmup; i++ {
+		_, _ = grpcListPage(client, cfg.PageSize, "", cfg.RPCTimeout)
+	}
+
+	return runWorkers(cfg, listOperations, counter, func(a, b int, out chan<- opResult) {
+		token := ""
+		for i := a; i < b; i++ {
+			t0 := time.Now()
+			next, err := grpcListPage(client, cfg.PageSize, token, cfg.RPCTimeout)
+			out <- opResult{"list", time.Since(t0), err}
+			token = next
+		}
+	}), nil
+}
+
+func grpcListPage(client userpb.UserServiceClient, size int, token string, to time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), to)
+	defer cancel()
+
+	resp, err := client.ListUsers(ctx, &userpb.ListUsersRequest{PageSize: int32(size), PageToken: token})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetNextPageToken(), nil
+}
+
+func grpcSeed(client userpb.UserServiceClient, n int, to time.Duration) ([]string, error) {
+	var ids []string
+	for start := 0; start < n; start += seedChunk {
+		users := make([]wireUser, 0, seedChunk)
+		for i := start; i < min(start+seedChunk, n); i++ {
+			users = append(users, makeUserPayload("grpc-seed", grpcEmailDomain, i, createDataSalt))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), to)
+		resp, err := client.BatchCreateUsers(ctx, toBatchCreateRequest(users))
+		cancel()
+		if err == nil {
+			err = checkBatchResults(resp)
+		}
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, grpcBatchIDs(resp)...)
+	}
+	return ids, nil
+}
+
+func grpcUnseed(client userpb.UserServiceClient, ids []string, to time.Duration) {
+	for start := 0; start < len(ids); start += seedChunk {
+		ctx, cancel := context.WithTimeout(context.Background(), to)
+		_, _ = client.BatchDeleteUsers(ctx, &userpb.BatchDeleteUsersRequest{Ids: ids[start:min(start+seedChunk, len(ids))]})
+		cancel()
+	}
+}
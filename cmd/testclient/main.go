@@ -2,20 +2,31 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
 
+	"golang-grpc/internal/auth"
 	userpb "golang-grpc/pkg/gen/user/v1"
 )
 
@@ -26,6 +37,13 @@ const (
 	defaultConcurrency  = 5
 	defaultWarmup       = 20
 	defaultRPCTimeoutMs = 2000
+	defaultScenarios    = "crud"
+	defaultAvatarBytes  = 1 << 20
+	defaultAvatarChunk  = 64 << 10
+	defaultBatchSize    = 50
+	defaultListSeed     = 10000
+	defaultPageSize     = 100
+	maxResponseBytes    = 64 << 20
 
 	payloadBioRepeat   = 64
 	payloadAvatarBytes = 4096
@@ -35,10 +53,39 @@ const (
 	addressDataSalt    = 29
 	httpEmailDomain    = "example.com"
 	grpcEmailDomain    = "rpc.example"
+
+	compressionNone = "none"
+	compressionGzip = "gzip"
 )
 
 var operationsOrder = []string{"create", "update", "get", "delete"}
 
+// runID makes generated emails unique per run, since the server rejects a
+// second user with an email that is already taken.
+var runID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// scenario groups the transport variants of one workload so each runs an
+// identical sequence of operations.
+type scenario struct {
+	name     string
+	variants []variant
+}
+
+type variant struct {
+	label string
+	run   func(benchConfig, *tls.Config) (batchResult, error)
+}
+
+var scenarios = []scenario{
+	{name: "crud", variants: []variant{{"HTTP", measureHTTPBatch}, {"gRPC", measureGRPCBatch}}},
+	{name: "avatar", variants: []variant{{"HTTP", measureHTTPAvatar}, {"gRPC", measureGRPCAvatar}}},
+	{name: "batch", variants: []variant{{"HTTP", measureHTTPBatchOps}, {"gRPC", measureGRPCBatchOps}}},
+	{name: "list", variants: []variant{{"HTTP", measureHTTPList}, {"gRPC", measureGRPCList}}},
+	{name: "patch", variants: []variant{{"HTTP", measureHTTPPatch}, {"gRPC", measureGRPCPatch}}},
+	{name: "cached", variants: []variant{{"HTTP", measureHTTPCached}, {"gRPC", measureGRPCCached}}},
+	{name: "stream", variants: []variant{{"HTTP", measureHTTPBatch}, {"gRPC unary", measureGRPCBatch}, {"gRPC stream", measureGRPCStream}}},
+}
+
 type benchConfig struct {
 	HTTPBaseURL string
 	GRPCAddress string
@@ -46,6 +93,111 @@ type benchConfig struct {
 	Concurrency int
 	Warmup      int
 	RPCTimeout  time.Duration
+
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSServerName string
+
+	HTTPCompression string
+	GRPCCompression string
+
+	Scenarios        []string
+	AvatarBytes      int
+	AvatarChunkBytes int
+	BatchSize        int
+	ListSeed         int
+	PageSize         int
+
+	// Reset empties the server's store before every phase; SeedUsers then
+	// fills it with that many generated users.
+	Reset     bool
+	SeedUsers int
+}
+
+func (c benchConfig) tlsEnabled() bool {
+	return c.TLSCAFile != "" || c.TLSCertFile != "" || strings.HasPrefix(c.HTTPBaseURL, "https://")
+}
+
+type batchResult struct {
+	order    []string
+	ops      map[string]stats
+	rejected int
+	failed   int
+	firstErr error
+	sent     int64
+	received int64
+}
+
+// wireCounter tallies the bytes written to and read from the network by all
+// connections dialed through it, after any TLS and compression.
+type wireCounter struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (w *wireCounter) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, counter: w}, nil
+}
+
+func (w *wireCounter) reset() {
+	w.sent.Store(0)
+	w.received.Store(0)
+}
+
+type countingConn struct {
+	net.Conn
+	counter *wireCounter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counter.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.sent.Add(int64(n))
+	return n, err
+}
+
+// gzipRequestTransport compresses request bodies. Response decompression is
+// left to http.Transport, which negotiates it through Accept-Encoding.
+type gzipRequestTransport struct {
+	base http.RoundTripper
+}
+
+func (t *gzipRequestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.base.RoundTrip(req)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := io.Copy(gz, req.Body)
+	req.Body.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	compressed := buf.Bytes()
+	clone := req.Clone(req.Context())
+	clone.Header.Set("Content-Encoding", compressionGzip)
+	clone.ContentLength = int64(len(compressed))
+	clone.Body = io.NopCloser(bytes.NewReader(compressed))
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	return t.base.RoundTrip(clone)
 }
 
 type stats struct {
@@ -146,7 +298,7 @@ func (w wireUser) toUpdateRequest(id string) *userpb.UpdateUserRequest {
 func makeUserPayload(prefix, domain string, idx, salt int) wireUser {
 	return wireUser{
 		Name:    fmt.Sprintf("%s-%d-%d", prefix, idx, salt),
-		Email:   fmt.Sprintf("%s%d+%d@%s", prefix, idx, salt, domain),
+		Email:   fmt.Sprintf("%s%d+%d.%s@%s", prefix, idx, salt, runID, domain),
 		Phone:   fmt.Sprintf("+1-800-%04d-%04d", (idx+salt)%10000, (idx*salt+addressDataSalt)%10000),
 		Address: fmt.Sprintf("%d %s Benchmark Blvd Suite %d", idx+salt+addressDataSalt, strings.ToUpper(prefix), (idx*salt)%500+1),
 		Bio:     buildBio(prefix, idx, salt),
@@ -191,8 +343,81 @@ func buildAvatar(prefix string, idx, salt int) []byte {
 }
 
 type opResult struct {
-	op string
-	d  time.Duration
+	op  string
+	d   time.Duration
+	err error
+}
+
+// isHandshakeRejection reports whether err comes from a failed TLS handshake,
+// e.g. the server refusing a missing or untrusted client certificate. gRPC
+// errors only match once handshakeCreds has attached the TLS error to them.
+func isHandshakeRejection(err error) bool {
+	var (
+		verifyErr *tls.CertificateVerificationError
+		headerErr tls.RecordHeaderError
+		opErr     *net.OpError
+	)
+	switch {
+	case errors.As(err, &verifyErr), errors.As(err, &headerErr):
+		return true
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// crypto/tls reports an alert sent by the peer as a remote error.
+		return true
+	}
+	return false
+}
+
+func collectResults(out <-chan opResult, order []string) batchResult {
+	collector := newCollector(order...)
+	result := batchResult{order: order}
+	for r := range out {
+		if r.err != nil {
+			if isHandshakeRejection(r.err) {
+				result.rejected++
+			} else {
+				result.failed++
+				if result.firstErr == nil {
+					result.firstErr = fmt.Errorf("%s: %w", r.op, r.err)
+				}
+			}
+			continue
+		}
+		collector.add(r.op, r.d)
+	}
+	result.ops = collector.stats()
+	return result
+}
+
+// runWorkers splits the iterations across cfg.Concurrency workers and
+// aggregates their results. Wire counters are reset first so only the
+// measured phase is reported.
+func runWorkers(cfg benchConfig, order []string, counter *wireCounter, work func(idxStart, idxEnd int, out chan<- opResult)) batchResult {
+	counter.reset()
+	out := make(chan opResult, cfg.Iterations*len(order))
+	var wg sync.WaitGroup
+
+	per := (cfg.Iterations + cfg.Concurrency - 1) / cfg.Concurrency
+	for w := 0; w < cfg.Concurrency; w++ {
+		start := w * per
+		end := min((w+1)*per, cfg.Iterations)
+		if start >= end {
+			break
+		}
+		wg.Add(1)
+		go func(a, b int) {
+			defer wg.Done()
+			work(a, b, out)
+		}(start, end)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	result := collectResults(out, order)
+	result.sent, result.received = counter.sent.Load(), counter.received.Load()
+	return result
 }
 
 func main() {
@@ -200,37 +425,71 @@ func main() {
 
 	cfg := loadConfig()
 	fmt.Printf(
-		"Config -> iterations: %d, concurrency: %d, warmup: %d, rpc-timeout: %s, http: %s, grpc: %s\n",
-		cfg.Iterations, cfg.Concurrency, cfg.Warmup, cfg.RPCTimeout, cfg.HTTPBaseURL, cfg.GRPCAddress,
+		"Config -> iterations: %d, concurrency: %d, warmup: %d, rpc-timeout: %s, http: %s, grpc: %s, tls: %t, compression: http=%s grpc=%s, scenarios: %s, reset: %t, seed: %d\n",
+		cfg.Iterations, cfg.Concurrency, cfg.Warmup, cfg.RPCTimeout, cfg.HTTPBaseURL, cfg.GRPCAddress, cfg.tlsEnabled(),
+		cfg.HTTPCompression, cfg.GRPCCompression, strings.Join(cfg.Scenarios, ","), cfg.Reset, cfg.SeedUsers,
 	)
 
-	httpStats, err := measureHTTPBatch(cfg)
-	if err != nil {
-		log.Fatalf("HTTP benchmark failed: %v", err)
+	var tlsConfig *tls.Config
+	if cfg.tlsEnabled() {
+		var err error
+		tlsConfig, err = auth.ClientTLSConfig(cfg.TLSCAFile, cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSServerName)
+		if err != nil {
+			log.Fatalf("TLS configuration failed: %v", err)
+		}
 	}
 
-	grpcStats, err := measureGRPCBatch(cfg)
-	if err != nil {
-		log.Fatalf("gRPC benchmark failed: %v", err)
-	}
+	preparer := newServerPreparer(cfg, tlsConfig)
+	for _, name := range cfg.Scenarios {
+		sc, ok := findScenario(name)
+		if !ok {
+			log.Fatalf("unknown scenario %q", name)
+		}
 
-	fmt.Println()
-	fmt.Println("HTTP results:")
-	printStats(httpStats)
+		results := make([]batchResult, len(sc.variants))
+		for i, v := range sc.variants {
+			if err := preparer.prepare(sc.name + "/" + v.label); err != nil {
+				log.Fatalf("preparing %s %s failed: %v", v.label, sc.name, err)
+			}
+			res, err := v.run(cfg, tlsConfig)
+			if err != nil {
+				log.Fatalf("%s %s benchmark failed: %v", v.label, sc.name, err)
+			}
+			results[i] = res
+		}
 
-	fmt.Println()
-	fmt.Println("gRPC results:")
-	printStats(grpcStats)
+		for i, v := range sc.variants {
+			fmt.Println()
+			fmt.Printf("%s results (%s):\n", v.label, sc.name)
+			printStats(results[i])
+		}
+	}
 }
 
-func printStats(results map[string]stats) {
-	for _, op := range operationsOrder {
-		stat, ok := results[op]
+func findScenario(name string) (scenario, bool) {
+	for _, sc := range scenarios {
+		if sc.name == name {
+			return sc, true
+		}
+	}
+	return scenario{}, false
+}
+
+func printStats(results batchResult) {
+	for _, op := range results.order {
+		stat, ok := results.ops[op]
 		if !ok || stat.Avg == 0 {
 			continue
 		}
-		fmt.Printf("  %-6s avg=%v | min=%v | max=%v\n", op, stat.Avg, stat.Min, stat.Max)
+		fmt.Printf("  %-8s avg=%v | min=%v | max=%v\n", op, stat.Avg, stat.Min, stat.Max)
+	}
+	if results.rejected > 0 {
+		fmt.Printf("  rejected TLS handshakes: %d\n", results.rejected)
+	}
+	if results.failed > 0 {
+		fmt.Printf("  failed requests: %d (first: %v)\n", results.failed, results.firstErr)
 	}
+	fmt.Printf("  bytes on wire: sent=%d | received=%d\n", results.sent, results.received)
 }
 
 func loadConfig() benchConfig {
@@ -241,6 +500,24 @@ func loadConfig() benchConfig {
 		Concurrency: getEnvInt("BENCH_CONCURRENCY", defaultConcurrency),
 		Warmup:      getEnvInt("BENCH_WARMUP", defaultWarmup),
 		RPCTimeout:  time.Duration(getEnvInt("BENCH_RPC_TIMEOUT_MS", defaultRPCTimeoutMs)) * time.Millisecond,
+
+		TLSCAFile:     getEnv("BENCH_TLS_CA_FILE", ""),
+		TLSCertFile:   getEnv("BENCH_TLS_CERT_FILE", ""),
+		TLSKeyFile:    getEnv("BENCH_TLS_KEY_FILE", ""),
+		TLSServerName: getEnv("BENCH_TLS_SERVER_NAME", ""),
+
+		HTTPCompression: strings.ToLower(getEnv("BENCH_HTTP_COMPRESSION", compressionNone)),
+		GRPCCompression: strings.ToLower(getEnv("BENCH_GRPC_COMPRESSION", compressionNone)),
+
+		Scenarios:        getEnvList("BENCH_SCENARIOS", defaultScenarios),
+		AvatarBytes:      getEnvInt("BENCH_AVATAR_BYTES", defaultAvatarBytes),
+		AvatarChunkBytes: getEnvInt("BENCH_AVATAR_CHUNK_BYTES", defaultAvatarChunk),
+		BatchSize:        getEnvInt("BENCH_BATCH_SIZE", defaultBatchSize),
+		ListSeed:         getEnvInt("BENCH_LIST_SEED", defaultListSeed),
+		PageSize:         getEnvInt("BENCH_PAGE_SIZE", defaultPageSize),
+
+		Reset:     getEnvBool("BENCH_RESET", false),
+		SeedUsers: getEnvInt("BENCH_SEED_USERS", 0),
 	}
 }
 
@@ -251,6 +528,25 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvList(key, fallback string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
 func getEnvInt(key string, fallback int) int {
 	if value, ok := os.LookupEnv(key); ok {
 		if parsed, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && parsed > 0 {
@@ -262,16 +558,29 @@ func getEnvInt(key string, fallback int) int {
 
 // -------------------- HTTP --------------------
 
-func measureHTTPBatch(cfg benchConfig) (map[string]stats, error) {
+func newHTTPClient(cfg benchConfig, tlsConfig *tls.Config) (*http.Client, *wireCounter) {
+	counter := &wireCounter{}
 	transport := &http.Transport{
+		DialContext:         counter.dial,
 		MaxIdleConns:        1024,
 		MaxIdleConnsPerHost: 1024,
 		IdleConnTimeout:     90 * time.Second,
+		TLSClientConfig:     tlsConfig,
+		DisableCompression:  cfg.HTTPCompression != compressionGzip,
+	}
+	var roundTripper http.RoundTripper = transport
+	if cfg.HTTPCompression == compressionGzip {
+		roundTripper = &gzipRequestTransport{base: transport}
 	}
 	client := &http.Client{
-		Transport: transport,
+		Transport: roundTripper,
 		Timeout:   cfg.RPCTimeout,
 	}
+	return client, counter
+}
+
+func measureHTTPBatch(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	client, counter := newHTTPClient(cfg, tlsConfig)
 	usersURL := cfg.HTTPBaseURL + "/users"
 
 	// Warm-up: few create and delete operations without measurements
@@ -281,34 +590,9 @@ func measureHTTPBatch(cfg benchConfig) (map[string]stats, error) {
 		_ = httpDeleteUser(client, usersURL, u.ID)
 	}
 
-	collector := newCollector(operationsOrder...)
-	out := make(chan opResult, cfg.Iterations*len(operationsOrder))
-	var wg sync.WaitGroup
-
-	per := (cfg.Iterations + cfg.Concurrency - 1) / cfg.Concurrency
-	for w := 0; w < cfg.Concurrency; w++ {
-		start := w * per
-		end := min((w+1)*per, cfg.Iterations)
-		if start >= end {
-			break
-		}
-		wg.Add(1)
-		go func(a, b int) {
-			defer wg.Done()
-			runHTTPWorker(client, usersURL, a, b, cfg.RPCTimeout, out)
-		}(start, end)
-	}
-
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-
-	for r := range out {
-		collector.add(r.op, r.d)
-	}
-
-	return collector.stats(), nil
+	return runWorkers(cfg, operationsOrder, counter, func(a, b int, out chan<- opResult) {
+		runHTTPWorker(client, usersURL, a, b, cfg.RPCTimeout, out)
+	}), nil
 }
 
 func runHTTPWorker(client *http.Client, usersURL string, idxStart, idxEnd int, to time.Duration, out chan<- opResult) {
@@ -319,28 +603,26 @@ func runHTTPWorker(client *http.Client, usersURL string, idxStart, idxEnd int, t
 		t0 := time.Now()
 		created, err := httpCreateUser(client, usersURL, createPayload)
 		if err != nil {
+			out <- opResult{op: "create", err: err}
 			continue
 		}
-		out <- opResult{"create", time.Since(t0)}
+		out <- opResult{"create", time.Since(t0), nil}
 
 		// update
 		updatePayload := makeUserPayload("http-user", httpEmailDomain, i, updateDataSalt)
 		t0 = time.Now()
-		if _, err := httpUpdateUser(client, usersURL, created.ID, updatePayload); err == nil {
-			out <- opResult{"update", time.Since(t0)}
-		}
+		_, err = httpUpdateUser(client, usersURL, created.ID, updatePayload)
+		out <- opResult{"update", time.Since(t0), err}
 
 		// get
 		t0 = time.Now()
-		if _, err := httpGetUser(client, usersURL, created.ID); err == nil {
-			out <- opResult{"get", time.Since(t0)}
-		}
+		_, err = httpGetUser(client, usersURL, created.ID)
+		out <- opResult{"get", time.Since(t0), err}
 
 		// delete
 		t0 = time.Now()
-		if err := httpDeleteUser(client, usersURL, created.ID); err == nil {
-			out <- opResult{"delete", time.Since(t0)}
-		}
+		err = httpDeleteUser(client, usersURL, created.ID)
+		out <- opResult{"delete", time.Since(t0), err}
 	}
 }
 
@@ -439,14 +721,103 @@ func httpDeleteUser(client *http.Client, usersURL, id string) error {
 
 // -------------------- gRPC --------------------
 
-func measureGRPCBatch(cfg benchConfig) (map[string]stats, error) {
-	conn, err := grpc.Dial(
-		cfg.GRPCAddress,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithBlock(),
-	)
+func dialGRPC(cfg benchConfig, tlsConfig *tls.Config) (*grpc.ClientConn, *wireCounter, error) {
+	counter := &wireCounter{}
+	var creds *handshakeCreds
+	dialOpts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			if creds != nil {
+				creds.rejection.Store(nil)
+			}
+			return counter.dial(ctx, "tcp", addr)
+		}),
+		// Large list pages exceed the 4 MiB client default.
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(maxResponseBytes)),
+	}
+	if tlsConfig != nil {
+		// Not blocking on dial lets a rejected handshake surface on each RPC,
+		// where it is counted, instead of stalling the connection attempt.
+		creds = &handshakeCreds{TransportCredentials: credentials.NewTLS(tlsConfig)}
+		dialOpts = append(dialOpts,
+			grpc.WithTransportCredentials(creds),
+			grpc.WithUnaryInterceptor(creds.unary),
+			grpc.WithStreamInterceptor(creds.stream),
+		)
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+	}
+	if cfg.GRPCCompression == compressionGzip {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcgzip.Name)))
+	}
+	conn, err := grpc.Dial(cfg.GRPCAddress, dialOpts...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return conn, counter, nil
+}
+
+// handshakeCreds remembers why the server rejected the latest connection.
+// gRPC fails RPCs on a broken connection with a bare Unavailable status, so
+// its interceptors attach the TLS error to those failures. Under TLS 1.3 the
+// server's alert only arrives on the first read after the handshake, which
+// is why the connection's reads are watched as well.
+type handshakeCreds struct {
+	credentials.TransportCredentials
+	rejection atomic.Pointer[error]
+}
+
+func (c *handshakeCreds) ClientHandshake(ctx context.Context, authority string, raw net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, raw)
+	if err != nil {
+		c.record(err)
+		return nil, nil, err
+	}
+	return &rejectionConn{Conn: conn, creds: c}, info, nil
+}
+
+func (c *handshakeCreds) record(err error) {
+	if isHandshakeRejection(err) {
+		c.rejection.Store(&err)
+	}
+}
+
+// explain wraps an Unavailable err with the recorded rejection, if any.
+func (c *handshakeCreds) explain(err error) error {
+	if status.Code(err) != codes.Unavailable {
+		return err
+	}
+	if rejection := c.rejection.Load(); rejection != nil {
+		return fmt.Errorf("%w: %w", err, *rejection)
+	}
+	return err
+}
+
+func (c *handshakeCreds) unary(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return c.explain(invoker(ctx, method, req, reply, cc, opts...))
+}
+
+func (c *handshakeCreds) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	return s, c.explain(err)
+}
+
+type rejectionConn struct {
+	net.Conn
+	creds *handshakeCreds
+}
+
+func (c *rejectionConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		c.creds.record(err)
+	}
+	return n, err
+}
+
+func measureGRPCBatch(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	conn, counter, err := dialGRPC(cfg, tlsConfig)
+	if err != nil {
+		return batchResult{}, err
 	}
 	defer conn.Close()
 
@@ -465,34 +836,9 @@ func measureGRPCBatch(cfg benchConfig) (map[string]stats, error) {
 		}
 	}
 
-	collector := newCollector(operationsOrder...)
-	out := make(chan opResult, cfg.Iterations*len(operationsOrder))
-	var wg sync.WaitGroup
-
-	per := (cfg.Iterations + cfg.Concurrency - 1) / cfg.Concurrency
-	for w := 0; w < cfg.Concurrency; w++ {
-		start := w * per
-		end := min((w+1)*per, cfg.Iterations)
-		if start >= end {
-			break
-		}
-		wg.Add(1)
-		go func(a, b int) {
-			defer wg.Done()
-			runGRPCWorker(client, a, b, cfg.RPCTimeout, out)
-		}(start, end)
-	}
-
-	go func() {
-		wg.Wait()
-		close(out)
-	}()
-
-	for r := range out {
-		collector.add(r.op, r.d)
-	}
-
-	return collector.stats(), nil
+	return runWorkers(cfg, operationsOrder, counter, func(a, b int, out chan<- opResult) {
+		runGRPCWorker(client, a, b, cfg.RPCTimeout, out)
+	}), nil
 }
 
 func runGRPCWorker(client userpb.UserServiceClient, idxStart, idxEnd int, to time.Duration, out chan<- opResult) {
@@ -505,9 +851,10 @@ func runGRPCWorker(client userpb.UserServiceClient, idxStart, idxEnd int, to tim
 		created, err := client.CreateUser(ctx, createPayload.toCreateRequest())
 		cancel()
 		if err != nil {
+			out <- opResult{op: "create", err: err}
 			continue
 		}
-		out <- opResult{"create", time.Since(t0)}
+		out <- opResult{"create", time.Since(t0), nil}
 
 		id := created.GetUser().GetId()
 		updatePayload := makeUserPayload("grpc-user", grpcEmailDomain, i, updateDataSalt)
@@ -517,27 +864,21 @@ func runGRPCWorker(client userpb.UserServiceClient, idxStart, idxEnd int, to tim
 		ctx, cancel = context.WithTimeout(context.Background(), to)
 		_, err = client.UpdateUser(ctx, updatePayload.toUpdateRequest(id))
 		cancel()
-		if err == nil {
-			out <- opResult{"update", time.Since(t0)}
-		}
+		out <- opResult{"update", time.Since(t0), err}
 
 		// get
 		t0 = time.Now()
 		ctx, cancel = context.WithTimeout(context.Background(), to)
 		_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: id})
 		cancel()
-		if err == nil {
-			out <- opResult{"get", time.Since(t0)}
-		}
+		out <- opResult{"get", time.Since(t0), err}
 
 		// delete
 		t0 = time.Now()
 		ctx, cancel = context.WithTimeout(context.Background(), to)
 		_, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: id})
 		cancel()
-		if err == nil {
-			out <- opResult{"delete", time.Since(t0)}
-		}
+		out <- opResult{"delete", time.Since(t0), err}
 	}
 }
 
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// The patch scenario changes only the phone number of a fresh user, once by
+// resending the whole user and once as a partial update, so the cost of
+// shipping unchanged attributes (mostly the avatar) shows up directly.
+var patchOperations = []string{"create", "replace", "patch", "delete"}
+
+func patchPhone(idx int) string {
+	return fmt.Sprintf("+1-900-%04d-%04d", idx%10000, (idx*7+updateDataSalt)%10000)
+}
+
+// -------------------- HTTP --------------------
+
+func measureHTTPPatch(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	client, counter := newHTTPClient(cfg, tlsConfig)
+	usersURL := cfg.HTTPBaseURL + "/users"
+
+	for i := 0; i < cfg.Warmup; i++ {
+		payload := makeUserPayload("warm-http-patch", httpEmailDomain, i, createDataSalt)
+		if u, err := httpCreateUser(client, usersURL, payload); err == nil {
+			_, _ = httpPatchUser(client, usersURL, u.ID, map[string]any{"phone": patchPhone(i)})
+			_ = httpDeleteUser(client, usersURL, u.ID)
+		}
+	}
+
+	return runWorkers(cfg, patchOperations, counter, func(a, b int, out chan<- opResult) {
+		for i := a; i < b; i++ {
+			payload := makeUserPayload("http-patch", httpEmailDomain, i, createDataSalt)
+
+			t0 := time.Now()
+			created, err := httpCreateUser(client, usersURL, payload)
+			if err != nil {
+				out <- opResult{op: "create", err: err}
+				continue
+			}
+			out <- opResult{"create", time.Since(t0), nil}
+
+			payload.Phone = patchPhone(i)
+			t0 = time.Now()
+			_, err = httpUpdateUser(client, usersURL, created.ID, payload)
+			out <- opResult{"replace", time.Since(t0), err}
+
+			t0 = time.Now()
+			_, err = httpPatchUser(client, usersURL, created.ID, map[string]any{"phone": patchPhone(i + 1)})
+			out <- opResult{"patch", time.Since(t0), err}
+
+			t0 = time.Now()
+			err = httpDeleteUser(client, usersURL, created.ID)
+			out <- opResult{"delete", time.Since(t0), err}
+		}
+	}), nil
+}
+
+func httpPatchUser(client *http.Client, usersURL, id string, patch map[string]any) (wireUser, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return wireUser{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPatch, usersURL+"/"+id, bytes.NewReader(body))
+	if err != nil {
+		return wireUser{}, err
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return wireUser{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return wireUser{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var patched wireUser
+	if err := json.NewDecoder(resp.Body).Decode(&patched); err != nil {
+		return wireUser{}, err
+	}
+	return patched, nil
+}
+
+// -------------------- gRPC --------------------
+
+func measureGRPCPatch(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	conn, counter, err := dialGRPC(cfg, tlsConfig)
+	if err != nil {
+		return batchResult{}, err
+	}
+	defer conn.Close()
+
+	client := userpb.NewUserServiceClient(conn)
+	call := func(fn func(ctx context.Context) error) error {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.RPCTimeout)
+		defer cancel()
+		return fn(ctx)
+	}
+
+	for i := 0; i < cfg.Warmup; i++ {
+		payload := makeUserPayload("warm-grpc-patch", grpcEmailDomain, i, createDataSalt)
+		var id string
+		err := call(func(ctx context.Context) error {
+			resp, err := client.CreateUser(ctx, payload.toCreateRequest())
+			id = resp.GetUser().GetId()
+			return err
+		})
+		if err == nil {
+			_ = call(func(ctx context.Context) error {
+				_, err := client.UpdateUser(ctx, phonePatchRequest(id, patchPhone(i)))
+				return err
+			})
+			_ = call(func(ctx context.Context) error {
+				_, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: id})
+				return err
+			})
+		}
+	}
+
+	return runWorkers(cfg, patchOperations, counter, func(a, b int, out chan<- opResult) {
+		for i := a; i < b; i++ {
+			payload := makeUserPayload("grpc-patch", grpcEmailDomain, i, createDataSalt)
+
+			var id string
+			t0 := time.Now()
+			err := call(func(ctx context.Context) error {
+				resp, err := client.CreateUser(ctx, payload.toCreateRequest())
+				id = resp.GetUser().GetId()
+				return err
+			})
+			if err != nil {
+				out <- opResult{op: "create", err: err}
+				continue
+			}
+			out <- opResult{"create", time.Since(t0), nil}
+
+			payload.Phone = patchPhone(i)
+			t0 = time.Now()
+			err = call(func(ctx context.Context) error {
+				_, err := client.UpdateUser(ctx, payload.toUpdateRequest(id))
+				return err
+			})
+			out <- opResult{"replace", time.Since(t0), err}
+
+			t0 = time.Now()
+			err = call(func(ctx context.Context) error {
+				_, err := client.UpdateUser(ctx, phonePatchRequest(id, patchPhone(i+1)))
+				return err
+			})
+			out <- opResult{"patch", time.Since(t0), err}
+
+			t0 = time.Now()
+			err = call(func(ctx context.Context) error {
+				_, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: id})
+				return err
+			})
+			out <- opResult{"delete", time.Since(t0), err}
+		}
+	}), nil
+}
+
+func phonePatchRequest(id, phone string) *userpb.UpdateUserRequest {
+	return &userpb.UpdateUserRequest{
+		Id:         id,
+		Phone:      phone,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"phone"}},
+	}
+}
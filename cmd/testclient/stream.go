@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// measureGRPCStream runs the crud sequence over one bidirectional
+// StreamUsers stream per worker, timing each command round trip.
+func measureGRPCStream(cfg benchConfig, tlsConfig *tls.Config) (batchResult, error) {
+	conn, counter, err := dialGRPC(cfg, tlsConfig)
+	if err != nil {
+		return batchResult{}, err
+	}
+	defer conn.Close()
+
+	client := userpb.NewUserServiceClient(conn)
+
+	// Warm-up
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout(cfg, 2*cfg.Warmup))
+	if stream, err := client.StreamUsers(ctx); err == nil {
+		session := &commandStream{stream: stream}
+		for i := 0; i < cfg.Warmup; i++ {
+			payload := makeUserPayload("warm-grpc-stream", grpcEmailDomain, i, createDataSalt)
+			if u, err := session.do(&userpb.UserCommand{Command: &userpb.UserCommand_Create{Create: payload.toCreateRequest()}}); err == nil {
+				_, _ = session.do(&userpb.UserCommand{Command: &userpb.UserCommand_Delete{Delete: &userpb.DeleteUserRequest{Id: u.GetId()}}})
+			}
+		}
+		_ = stream.CloseSend()
+	}
+	cancel()
+
+	return runWorkers(cfg, operationsOrder, counter, func(a, b int, out chan<- opResult) {
+		runGRPCStreamWorker(cfg, client, a, b, out)
+	}), nil
+}
+
+// streamTimeout bounds a stream that carries the given number of commands,
+// allowing each the RPC timeout a unary call gets.
+func streamTimeout(cfg benchConfig, commands int) time.Duration {
+	return cfg.RPCTimeout * time.Duration(max(commands, 1))
+}
+
+func runGRPCStreamWorker(cfg benchConfig, client userpb.UserServiceClient, idxStart, idxEnd int, out chan<- opResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), streamTimeout(cfg, len(operationsOrder)*(idxEnd-idxStart)))
+	defer cancel()
+
+	stream, err := client.StreamUsers(ctx)
+	if err != nil {
+		for i := idxStart; i < idxEnd; i++ {
+			out <- opResult{op: "create", err: err}
+		}
+		return
+	}
+	defer stream.CloseSend()
+	session := &commandStream{stream: stream}
+
+	for i := idxStart; i < idxEnd; i++ {
+		createPayload := makeUserPayload("grpc-stream-user", grpcEmailDomain, i, createDataSalt)
+
+		// create
+		t0 := time.Now()
+		created, err := session.do(&userpb.UserCommand{Command: &userpb.UserCommand_Create{Create: createPayload.toCreateRequest()}})
+		if err != nil {
+			out <- opResult{op: "create", err: err}
+			continue
+		}
+		out <- opResult{"create", time.Since(t0), nil}
+
+		id := created.GetId()
+		updatePayload := makeUserPayload("grpc-stream-user", grpcEmailDomain, i, updateDataSalt)
+
+		// update
+		t0 = time.Now()
+		_, err = session.do(&userpb.UserCommand{Command: &userpb.UserCommand_Update{Update: updatePayload.toUpdateRequest(id)}})
+		out <- opResult{"update", time.Since(t0), err}
+
+		// get
+		t0 = time.Now()
+		_, err = session.do(&userpb.UserCommand{Command: &userpb.UserCommand_Get{Get: &userpb.GetUserRequest{Id: id}}})
+		out <- opResult{"get", time.Since(t0), err}
+
+		// delete
+		t0 = time.Now()
+		_, err = session.do(&userpb.UserCommand{Command: &userpb.UserCommand_Delete{Delete: &userpb.DeleteUserRequest{Id: id}}})
+		out <- opResult{"delete", time.Since(t0), err}
+	}
+}
+
+// commandStream sends one command at a time and waits for its result.
+type commandStream struct {
+	stream userpb.UserService_StreamUsersClient
+	seq    int
+}
+
+func (c *commandStream) do(cmd *userpb.UserCommand) (*userpb.User, error) {
+	c.seq++
+	cmd.RequestId = strconv.Itoa(c.seq)
+	if err := c.stream.Send(cmd); err != nil {
+		return nil, err
+	}
+	res, err := c.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if res.GetRequestId() != cmd.GetRequestId() {
+		return nil, fmt.Errorf("result for request %s, want %s", res.GetRequestId(), cmd.GetRequestId())
+	}
+	if code := codes.Code(res.GetCode()); code != codes.OK {
+		return nil, fmt.Errorf("%s: %s", code, res.GetMessage())
+	}
+	return res.GetUser(), nil
+}
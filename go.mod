@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/gin-gonic/gin v1.9.1
 	github.com/golang/protobuf v1.5.4
+	go.etcd.io/bbolt v1.3.10
 	google.golang.org/grpc v1.67.0
 	google.golang.org/protobuf v1.34.2
 )
@@ -0,0 +1,17 @@
+package auth
+
+import "context"
+
+type subjectKey struct{}
+
+// WithClientSubject stores the verified client certificate subject in ctx.
+func WithClientSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// ClientSubject returns the client certificate subject, if the caller was
+// authenticated with mTLS.
+func ClientSubject(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(subjectKey{}).(string)
+	return subject, ok
+}
@@ -12,12 +12,113 @@ const (
 	defaultHTTPPort        = 8087
 	defaultGRPCPort        = 50055
 	defaultShutdownSeconds = 5
+
+	defaultMaxRequestBytes  = 4 << 20
+	defaultMaxResponseBytes = 64 << 20
+	defaultMaxAvatarBytes   = 1 << 20
+	defaultMaxTags          = 64
+	defaultMaxFieldLength   = 64 << 10
+	defaultMaxBatchSize     = 1000
+	defaultMaxPageSize      = 1000
+
+	defaultCacheMaxAgeSeconds       = 0
+	defaultIdempotencyWindowSeconds = 24 * 60 * 60
+	defaultIdempotencyMaxKeys       = 100000
+
+	StoreMemory      = "memory"
+	StoreBolt        = "bolt"
+	StoreSharded     = "sharded"
+	defaultStorePath = "users.db"
+	defaultShards    = 16
+	defaultRevisions = 10
+
+	defaultReapIntervalSeconds = 30
+
+	defaultEviction = "reject"
+
+	defaultWALFsync                = "interval"
+	defaultWALFsyncIntervalMs      = 100
+	defaultSnapshotIntervalSeconds = 300
+
+	defaultAdminSnapshotDir = "snapshots"
 )
 
 type Config struct {
 	HTTPAddr      string
 	GRPCAddr      string
 	ShutdownGrace time.Duration
+	TLS           TLSConfig
+	Store         StoreConfig
+
+	// MaxRequestBytes bounds HTTP request bodies and received gRPC messages;
+	// MaxResponseBytes bounds sent gRPC messages.
+	MaxRequestBytes  int
+	MaxResponseBytes int
+	MaxAvatarBytes   int
+	MaxTags          int
+	MaxFieldLength   int
+	MaxBatchSize     int
+	MaxPageSize      int
+
+	// CacheMaxAge is how long HTTP clients may reuse a user without
+	// revalidating it; zero makes them revalidate every time.
+	CacheMaxAge time.Duration
+
+	// IdempotencyWindow is how long create results are remembered under
+	// their idempotency key; zero disables idempotency keys.
+	// IdempotencyMaxKeys caps how many keys are remembered at once.
+	IdempotencyWindow  time.Duration
+	IdempotencyMaxKeys int
+
+	Admin AdminConfig
+}
+
+// AdminConfig controls the admin endpoints, which are off unless Enabled.
+// Snapshot files are read and written inside SnapshotDir.
+type AdminConfig struct {
+	Enabled     bool
+	SnapshotDir string
+}
+
+// TLSConfig holds the certificate paths shared by both listeners. Setting
+// ClientCAFile turns on mutual TLS.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// Enabled reports whether any TLS setting is present. A partial setting,
+// such as a client CA without a key pair, still counts, so that the server
+// fails to start instead of silently serving plaintext.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.ClientCAFile != ""
+}
+
+// StoreConfig selects the user repository: StoreMemory, StoreSharded for
+// an in-memory store split into Shards independently locked shards, or
+// StoreBolt for an embedded bbolt database at Path. Setting WALDir makes the
+// memory store durable with a write-ahead log and snapshots in that
+// directory. Revisions is how many versions of each user the revision
+// history returns, the current one included. ReapInterval is how often
+// expired users are removed; zero leaves them in place. MaxUsers and
+// MaxBytes bound the memory backend, zero meaning no limit, and Eviction
+// picks what happens at the limit: "reject" or "lru".
+type StoreConfig struct {
+	Backend      string
+	Path         string
+	Shards       int
+	Revisions    int
+	ReapInterval time.Duration
+
+	MaxUsers int
+	MaxBytes int64
+	Eviction string
+
+	WALDir           string
+	WALFsync         string
+	WALFsyncInterval time.Duration
+	SnapshotInterval time.Duration
 }
 
 func Load() Config {
@@ -29,6 +130,41 @@ func Load() Config {
 		HTTPAddr:      httpAddr,
 		GRPCAddr:      grpcAddr,
 		ShutdownGrace: grace,
+		TLS: TLSConfig{
+			CertFile:     lookupEnv("TLS_CERT_FILE", ""),
+			KeyFile:      lookupEnv("TLS_KEY_FILE", ""),
+			ClientCAFile: lookupEnv("TLS_CLIENT_CA_FILE", ""),
+		},
+		Store: StoreConfig{
+			Backend:      lookupEnv("STORE_BACKEND", StoreMemory),
+			Path:         lookupEnv("STORE_PATH", defaultStorePath),
+			Shards:       lookupEnvInt("STORE_SHARDS", defaultShards),
+			Revisions:    lookupEnvInt("STORE_REVISIONS", defaultRevisions),
+			ReapInterval: time.Duration(lookupEnvInt("REAP_INTERVAL_SECONDS", defaultReapIntervalSeconds)) * time.Second,
+
+			MaxUsers: lookupEnvInt("STORE_MAX_USERS", 0),
+			MaxBytes: int64(lookupEnvInt("STORE_MAX_BYTES", 0)),
+			Eviction: lookupEnv("STORE_EVICTION", defaultEviction),
+
+			WALDir:           lookupEnv("WAL_DIR", ""),
+			WALFsync:         lookupEnv("WAL_FSYNC", defaultWALFsync),
+			WALFsyncInterval: time.Duration(lookupEnvInt("WAL_FSYNC_INTERVAL_MS", defaultWALFsyncIntervalMs)) * time.Millisecond,
+			SnapshotInterval: time.Duration(lookupEnvInt("SNAPSHOT_INTERVAL_SECONDS", defaultSnapshotIntervalSeconds)) * time.Second,
+		},
+		MaxRequestBytes:    lookupEnvInt("MAX_REQUEST_BYTES", defaultMaxRequestBytes),
+		MaxResponseBytes:   lookupEnvInt("MAX_RESPONSE_BYTES", defaultMaxResponseBytes),
+		MaxAvatarBytes:     lookupEnvInt("MAX_AVATAR_BYTES", defaultMaxAvatarBytes),
+		MaxTags:            lookupEnvInt("MAX_TAGS", defaultMaxTags),
+		MaxFieldLength:     lookupEnvInt("MAX_FIELD_LENGTH", defaultMaxFieldLength),
+		MaxBatchSize:       lookupEnvInt("MAX_BATCH_SIZE", defaultMaxBatchSize),
+		MaxPageSize:        lookupEnvInt("MAX_PAGE_SIZE", defaultMaxPageSize),
+		CacheMaxAge:        time.Duration(lookupEnvInt("HTTP_CACHE_MAX_AGE_SECONDS", defaultCacheMaxAgeSeconds)) * time.Second,
+		IdempotencyWindow:  time.Duration(lookupEnvInt("IDEMPOTENCY_WINDOW_SECONDS", defaultIdempotencyWindowSeconds)) * time.Second,
+		IdempotencyMaxKeys: lookupEnvInt("IDEMPOTENCY_MAX_KEYS", defaultIdempotencyMaxKeys),
+		Admin: AdminConfig{
+			Enabled:     lookupEnvBool("ADMIN_ENABLED", false),
+			SnapshotDir: lookupEnv("ADMIN_SNAPSHOT_DIR", defaultAdminSnapshotDir),
+		},
 	}
 }
 
@@ -48,6 +184,15 @@ func lookupEnvInt(key string, fallback int) int {
 	return fallback
 }
 
+func lookupEnvBool(key string, fallback bool) bool {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if parsed, err := strconv.ParseBool(v); err == nil {
+			return parsed
+		}
+	}
+	return fallback
+}
+
 func joinHostPort(host string, port int) string {
 	return host + ":" + strconv.Itoa(port)
 }
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// AdminOptions configures the admin endpoints. Snapshot paths given by
+// clients are resolved inside SnapshotDir and may not leave it.
+type AdminOptions struct {
+	SnapshotDir string
+}
+
+// Admin implements operational endpoints on top of a Service. Transports
+// only expose it when admin endpoints are enabled.
+type Admin struct {
+	svc         *Service
+	snapshotDir string
+	userpb.UnimplementedAdminServiceServer
+}
+
+func NewAdmin(svc *Service, opts AdminOptions) *Admin {
+	return &Admin{svc: svc, snapshotDir: opts.SnapshotDir}
+}
+
+// Export writes a snapshot to name inside the snapshot directory and returns
+// the path written and the number of users in it.
+func (a *Admin) Export(ctx context.Context, name string, format SnapshotFormat) (string, int, error) {
+	path, err := a.snapshotPath(name)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := a.svc.ExportSnapshot(ctx, path, format)
+	return path, n, err
+}
+
+// Import restores the snapshot name inside the snapshot directory.
+func (a *Admin) Import(ctx context.Context, name string, format SnapshotFormat, mode RestoreMode) (ImportResult, error) {
+	path, err := a.snapshotPath(name)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return a.svc.ImportSnapshot(ctx, path, format, mode)
+}
+
+func (a *Admin) snapshotPath(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%w: snapshot path is required", ErrInvalidInput)
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: snapshot path must be relative to the snapshot directory", ErrInvalidInput)
+	}
+	return filepath.Join(a.snapshotDir, name), nil
+}
+
+func (a *Admin) ExportSnapshot(ctx context.Context, req *userpb.ExportSnapshotRequest) (*userpb.ExportSnapshotResponse, error) {
+	path, n, err := a.Export(ctx, req.GetPath(), snapshotFormatFromProto(req.GetFormat()))
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &userpb.ExportSnapshotResponse{Path: path, Users: int64(n)}, nil
+}
+
+func (a *Admin) ImportSnapshot(ctx context.Context, req *userpb.ImportSnapshotRequest) (*userpb.ImportSnapshotResponse, error) {
+	var mode RestoreMode
+	switch req.GetMode() {
+	case userpb.ImportMode_IMPORT_MODE_REPLACE:
+		mode = RestoreReplace
+	case userpb.ImportMode_IMPORT_MODE_MERGE:
+		mode = RestoreMerge
+	}
+	result, err := a.Import(ctx, req.GetPath(), snapshotFormatFromProto(req.GetFormat()), mode)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	resp := &userpb.ImportSnapshotResponse{
+		Restored: result.Accepted,
+		Rejected: result.Rejected,
+		Errors:   make([]*userpb.ImportError, len(result.Errors)),
+	}
+	for i, e := range result.Errors {
+		resp.Errors[i] = &userpb.ImportError{Index: e.Index, Message: e.Err.Error()}
+	}
+	return resp, nil
+}
+
+func snapshotFormatFromProto(f userpb.SnapshotFormat) SnapshotFormat {
+	switch f {
+	case userpb.SnapshotFormat_SNAPSHOT_FORMAT_JSON:
+		return SnapshotJSON
+	case userpb.SnapshotFormat_SNAPSHOT_FORMAT_PROTOBUF:
+		return SnapshotProtobuf
+	default:
+		return ""
+	}
+}
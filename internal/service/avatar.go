@@ -0,0 +1,147 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+const avatarChunkBytes = 64 << 10
+
+// PutAvatar streams the avatar for user id from r, enforcing the avatar size
+// limit while reading rather than after buffering the whole body. size is
+// the exact length of r if known, such as an HTTP Content-Length, and -1
+// otherwise. A non-zero expectedVersion makes the write conditional, and a
+// mismatch is reported before the body is read.
+func (s *Service) PutAvatar(_ context.Context, id string, r io.Reader, size, expectedVersion int64) (user.User, error) {
+	if err := validateIdentifier(id); err != nil {
+		return user.User{}, err
+	}
+	u, err := s.store.Get(id)
+	if err != nil {
+		return user.User{}, err
+	}
+	if expectedVersion != 0 && u.Version != expectedVersion {
+		return user.User{}, user.ErrVersionMismatch
+	}
+
+	avatar, err := readAvatar(r, size, s.limits.MaxAvatarBytes)
+	if err != nil {
+		return user.User{}, err
+	}
+	return s.store.SetAvatar(id, avatar, expectedVersion)
+}
+
+// readAvatar reads r into a slice of exactly its length, failing once more
+// than limit bytes arrive (zero means no limit). Under a limit, a known size
+// is checked before reading and allocated once. Otherwise the avatar is read
+// in full and copied into a right-sized slice, so the store never keeps the
+// spare capacity of a grown buffer alive.
+func readAvatar(r io.Reader, size int64, limit int) ([]byte, error) {
+	if limit > 0 {
+		if size > int64(limit) {
+			return nil, avatarTooLarge(limit)
+		}
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+	// Without a limit, a client-declared size must not pick the allocation.
+	if size >= 0 && limit > 0 {
+		avatar := make([]byte, size)
+		if _, err := io.ReadFull(r, avatar); err != nil {
+			return nil, err
+		}
+		return avatar, nil
+	}
+
+	avatar, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(avatar) > limit {
+		return nil, avatarTooLarge(limit)
+	}
+	if cap(avatar) > len(avatar) {
+		avatar = bytes.Clone(avatar)
+	}
+	return avatar, nil
+}
+
+func avatarTooLarge(limit int) error {
+	return fmt.Errorf("%w: avatar exceeds %d bytes", ErrTooLarge, limit)
+}
+
+// GetAvatar returns the stored avatar. The slice is shared with the store
+// and must not be modified.
+func (s *Service) GetAvatar(ctx context.Context, id string) ([]byte, error) {
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return u.Avatar, nil
+}
+
+func (s *Service) UploadAvatar(stream userpb.UserService_UploadAvatarServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return serviceError(fmt.Errorf("%w: id is required", ErrInvalidInput))
+		}
+		return err
+	}
+
+	id := strings.TrimSpace(first.GetId())
+	u, err := s.PutAvatar(stream.Context(), id, &chunkReader{stream: stream, pending: first.GetChunk()}, -1, first.GetExpectedVersion())
+	if err != nil {
+		return serviceError(err)
+	}
+	return stream.SendAndClose(&userpb.UploadAvatarResponse{Id: id, Size: int64(len(u.Avatar))})
+}
+
+func (s *Service) DownloadAvatar(req *userpb.DownloadAvatarRequest, stream userpb.UserService_DownloadAvatarServer) error {
+	avatar, err := s.GetAvatar(stream.Context(), strings.TrimSpace(req.GetId()))
+	if err != nil {
+		return serviceError(err)
+	}
+
+	offset, length := req.GetOffset(), req.GetLength()
+	if offset < 0 || length < 0 || offset > int64(len(avatar)) {
+		return serviceError(fmt.Errorf("%w: range outside of avatar", ErrInvalidInput))
+	}
+	end := int64(len(avatar))
+	if length > 0 && offset+length < end {
+		end = offset + length
+	}
+
+	for pos := offset; pos < end; pos += avatarChunkBytes {
+		next := min(pos+avatarChunkBytes, end)
+		if err := stream.Send(&userpb.AvatarChunk{Chunk: avatar[pos:next]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkReader adapts an upload stream to io.Reader.
+type chunkReader struct {
+	stream  userpb.UserService_UploadAvatarServer
+	pending []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		msg, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.pending = msg.GetChunk()
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/status"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// BatchResult is the outcome of one item of a batch request. Err is nil on
+// success and otherwise classifies like the errors of the single-item calls.
+type BatchResult struct {
+	ID   string
+	User user.User
+	Err  error
+}
+
+// BatchCreate validates every item and stores the valid ones in a single
+// store operation. Invalid items are reported without failing the batch.
+// decodeErrs, when not nil, holds per item the error the transport hit
+// decoding it, such as a bad expiry; those items fail the same way.
+func (s *Service) BatchCreate(_ context.Context, attrs []user.Attributes, decodeErrs []error) ([]BatchResult, error) {
+	if err := s.limits.checkBatch(len(attrs)); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(attrs))
+	valid := make([]user.Attributes, 0, len(attrs))
+	positions := make([]int, 0, len(attrs))
+	for i, a := range attrs {
+		if decodeErrs != nil && decodeErrs[i] != nil {
+			results[i].Err = decodeErrs[i]
+			continue
+		}
+		clean := normalizeAttributes(a)
+		if err := s.validatePayload(clean); err != nil {
+			results[i].Err = err
+			continue
+		}
+		valid = append(valid, clean)
+		positions = append(positions, i)
+	}
+
+	for j, item := range s.store.CreateBatch(valid) {
+		results[positions[j]] = BatchResult{ID: item.User.ID, User: item.User, Err: item.Err}
+	}
+	return results, nil
+}
+
+func (s *Service) BatchGet(_ context.Context, ids []string) ([]BatchResult, error) {
+	return s.batchByID(ids, s.store.GetBatch)
+}
+
+// BatchDelete removes the users. Deleted records are not echoed back,
+// matching Delete.
+func (s *Service) BatchDelete(_ context.Context, ids []string) ([]BatchResult, error) {
+	results, err := s.batchByID(ids, s.store.DeleteBatch)
+	for i := range results {
+		results[i].User = user.User{}
+	}
+	return results, err
+}
+
+func (s *Service) batchByID(ids []string, op func([]string) []user.BatchItem) ([]BatchResult, error) {
+	if err := s.limits.checkBatch(len(ids)); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(ids))
+	valid := make([]string, 0, len(ids))
+	positions := make([]int, 0, len(ids))
+	for i, id := range ids {
+		id = strings.TrimSpace(id)
+		results[i].ID = id
+		if err := validateIdentifier(id); err != nil {
+			results[i].Err = err
+			continue
+		}
+		valid = append(valid, id)
+		positions = append(positions, i)
+	}
+
+	for j, item := range op(valid) {
+		results[positions[j]].User = item.User
+		results[positions[j]].Err = item.Err
+	}
+	return results, nil
+}
+
+func (s *Service) BatchCreateUsers(ctx context.Context, req *userpb.BatchCreateUsersRequest) (*userpb.BatchUsersResponse, error) {
+	attrs := make([]user.Attributes, len(req.GetUsers()))
+	decodeErrs := make([]error, len(attrs))
+	for i, u := range req.GetUsers() {
+		attrs[i], decodeErrs[i] = createRequestAttributes(u)
+	}
+	results, err := s.BatchCreate(ctx, attrs, decodeErrs)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return toBatchResponse(results), nil
+}
+
+func (s *Service) BatchGetUsers(ctx context.Context, req *userpb.BatchGetUsersRequest) (*userpb.BatchUsersResponse, error) {
+	results, err := s.BatchGet(ctx, req.GetIds())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return toBatchResponse(results), nil
+}
+
+func (s *Service) BatchDeleteUsers(ctx context.Context, req *userpb.BatchDeleteUsersRequest) (*userpb.BatchUsersResponse, error) {
+	results, err := s.BatchDelete(ctx, req.GetIds())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return toBatchResponse(results), nil
+}
+
+func toBatchResponse(results []BatchResult) *userpb.BatchUsersResponse {
+	resp := &userpb.BatchUsersResponse{
+		Results: make([]*userpb.BatchUserResult, len(results)),
+	}
+	for i, r := range results {
+		item := &userpb.BatchUserResult{Id: r.ID}
+		if r.Err != nil {
+			st := status.Convert(serviceError(r.Err))
+			item.Code = int32(st.Code())
+			item.Message = st.Message()
+		} else if r.User.ID != "" {
+			item.User = toProto(r.User)
+		}
+		resp.Results[i] = item
+	}
+	return resp
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// The benchmarks below measure allocations per request on the service data
+// path. Users carry a 64 KiB avatar, so a copy of it anywhere on the path
+// shows up as B/op in the tens of kilobytes.
+
+const benchAvatarBytes = 64 << 10
+
+var (
+	benchTags   = []string{"alpha", "beta", "gamma", "delta"}
+	benchAvatar = make([]byte, benchAvatarBytes)
+)
+
+func newBenchService() *Service {
+	return NewUserService(user.NewStore(user.Options{}), Options{})
+}
+
+func benchAttributes(i int) user.Attributes {
+	return user.Attributes{
+		Name:    "Bench User",
+		Email:   fmt.Sprintf("bench-%d@example.com", i),
+		Phone:   "+1-555-0100",
+		Address: "1 Benchmark Way",
+		Bio:     "Measures allocations on the service data path.",
+		Tags:    benchTags,
+		Avatar:  benchAvatar,
+	}
+}
+
+// seedBench creates n users and returns their IDs.
+func seedBench(b *testing.B, svc *Service, n int) []string {
+	b.Helper()
+	ids := make([]string, n)
+	for i := range ids {
+		u, err := svc.Create(context.Background(), benchAttributes(i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+// BenchmarkCreate is the path taken by POST /users after JSON decoding.
+func BenchmarkCreate(b *testing.B) {
+	svc := newBenchService()
+	attrs := make([]user.Attributes, b.N)
+	for i := range attrs {
+		attrs[i] = benchAttributes(i)
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.Create(ctx, attrs[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateUserRPC(b *testing.B) {
+	svc := newBenchService()
+	reqs := make([]*userpb.CreateUserRequest, b.N)
+	for i := range reqs {
+		a := benchAttributes(i)
+		reqs[i] = &userpb.CreateUserRequest{
+			Name: a.Name, Email: a.Email, Phone: a.Phone, Address: a.Address, Bio: a.Bio,
+			Tags: a.Tags, Avatar: a.Avatar,
+		}
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.CreateUser(ctx, reqs[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetUserRPC(b *testing.B) {
+	svc := newBenchService()
+	ids := seedBench(b, svc, 1000)
+	reqs := make([]*userpb.GetUserRequest, len(ids))
+	for i, id := range ids {
+		reqs[i] = &userpb.GetUserRequest{Id: id}
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.GetUser(ctx, reqs[i%len(reqs)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUpdateUserRPC(b *testing.B) {
+	svc := newBenchService()
+	ids := seedBench(b, svc, 1000)
+	reqs := make([]*userpb.UpdateUserRequest, len(ids))
+	for i, id := range ids {
+		a := benchAttributes(i)
+		reqs[i] = &userpb.UpdateUserRequest{
+			Id: id, Name: a.Name, Email: a.Email, Phone: a.Phone, Address: a.Address, Bio: a.Bio,
+			Tags: a.Tags, Avatar: a.Avatar,
+		}
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.UpdateUser(ctx, reqs[i%len(reqs)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListUsersRPC(b *testing.B) {
+	svc := newBenchService()
+	seedBench(b, svc, 1000)
+	req := &userpb.ListUsersRequest{PageSize: 100}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.ListUsers(ctx, req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetByEmail exercises the index lookup behind
+// GET /users/by-email/:email.
+func BenchmarkGetByEmail(b *testing.B) {
+	svc := newBenchService()
+	ids := seedBench(b, svc, 1000)
+	emails := make([]string, len(ids))
+	for i := range emails {
+		emails[i] = "bench-" + strconv.Itoa(i) + "@example.com"
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := svc.GetByEmail(ctx, emails[i%len(emails)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// maxTTLSeconds is the longest TTL a time.Duration can hold, about 292
+// years.
+const maxTTLSeconds = math.MaxInt64 / int64(time.Second)
+
+// ResolveExpiry returns the expiry a create or update asks for, given either
+// as an absolute time or as a TTL in seconds from now. Setting both, or a
+// negative or overlong TTL, is invalid; setting neither means the user
+// never expires.
+func ResolveExpiry(expiresAt *time.Time, ttlSeconds int64) (*time.Time, error) {
+	switch {
+	case ttlSeconds < 0:
+		return nil, fmt.Errorf("%w: ttl_seconds must not be negative", ErrInvalidInput)
+	case ttlSeconds > maxTTLSeconds:
+		return nil, fmt.Errorf("%w: ttl_seconds must not exceed %d", ErrInvalidInput, maxTTLSeconds)
+	case ttlSeconds == 0:
+		return expiresAt, nil
+	case expiresAt != nil:
+		return nil, fmt.Errorf("%w: set either expires_at or ttl_seconds, not both", ErrInvalidInput)
+	}
+	at := time.Now().UTC().Add(time.Duration(ttlSeconds) * time.Second)
+	return &at, nil
+}
+
+// checkExpiry rejects expiries that have already passed, which would create
+// users no one can read.
+func checkExpiry(expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expires_at must be in the future", ErrInvalidInput)
+	}
+	return nil
+}
+
+// expiryFromProto resolves the expiry fields shared by CreateUserRequest and
+// UpdateUserRequest.
+func expiryFromProto(expiresAt *timestamppb.Timestamp, ttlSeconds int64) (*time.Time, error) {
+	var at *time.Time
+	if expiresAt != nil {
+		t := expiresAt.AsTime()
+		at = &t
+	}
+	return ResolveExpiry(at, ttlSeconds)
+}
+
+// createRequestAttributes converts a create request, expiry included.
+func createRequestAttributes(req *userpb.CreateUserRequest) (attrs user.Attributes, err error) {
+	attrs = protoToAttributes(req.GetName(), req.GetEmail(), req.GetPhone(), req.GetAddress(), req.GetBio(), req.GetTags(), req.GetAvatar())
+	attrs.ExpiresAt, err = expiryFromProto(req.GetExpiresAt(), req.GetTtlSeconds())
+	return attrs, err
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResolveExpiry(t *testing.T) {
+	at := time.Now().Add(time.Hour)
+	tests := []struct {
+		name    string
+		at      *time.Time
+		ttl     int64
+		wantErr bool
+		want    func(*time.Time) bool
+	}{
+		{name: "neither", want: func(got *time.Time) bool { return got == nil }},
+		{name: "absolute", at: &at, want: func(got *time.Time) bool { return got == &at }},
+		{name: "ttl", ttl: 60, want: func(got *time.Time) bool {
+			d := time.Until(*got)
+			return d > 59*time.Second && d <= time.Minute
+		}},
+		{name: "longest ttl", ttl: maxTTLSeconds, want: func(got *time.Time) bool { return got.After(at) }},
+		{name: "both", at: &at, ttl: 60, wantErr: true},
+		{name: "negative ttl", ttl: -1, wantErr: true},
+		{name: "overflowing ttl", ttl: maxTTLSeconds + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveExpiry(tt.at, tt.ttl)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Errorf("%s: err = %v, want ErrInvalidInput", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || !tt.want(got) {
+			t.Errorf("%s: ResolveExpiry = %v, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestCheckExpiryRejectsThePast(t *testing.T) {
+	past := time.Now().Add(-time.Second)
+	if err := checkExpiry(&past); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("err = %v, want ErrInvalidInput", err)
+	}
+	if err := checkExpiry(nil); err != nil {
+		t.Fatalf("no expiry: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"golang-grpc/internal/auth"
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+const (
+	maxIdempotencyKeyLength = 255
+
+	idempotencyMetadataKey = "idempotency-key"
+	replayedMetadataKey    = "idempotent-replayed"
+)
+
+// CreateIdempotent creates a user at most once per idempotency key. A retry
+// with the same key and attributes within the idempotency window returns the
+// originally created user with replayed set, even if the first attempt is
+// still in flight. Reusing a key for different attributes is invalid. Failed
+// attempts are not remembered, so they can be retried. Keys are scoped to the
+// client certificate subject when mTLS is on. An empty key makes this a plain
+// Create; a key is invalid when the service has no idempotency window, as
+// dropping it would quietly give up the protection the caller asked for.
+func (s *Service) CreateIdempotent(ctx context.Context, key string, attrs user.Attributes) (u user.User, replayed bool, err error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		u, err = s.Create(ctx, attrs)
+		return u, false, err
+	}
+	if s.idempotency == nil {
+		return user.User{}, false, fmt.Errorf("%w: idempotency keys are disabled on this server", ErrInvalidInput)
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return user.User{}, false, fmt.Errorf("%w: idempotency key exceeds %d bytes", ErrInvalidInput, maxIdempotencyKeyLength)
+	}
+	if subject, ok := auth.ClientSubject(ctx); ok {
+		key = subject + "\x00" + key
+	}
+	fingerprint := fingerprintAttributes(normalizeAttributes(attrs))
+
+	for {
+		entry, owner := s.idempotency.begin(key, fingerprint)
+		if owner {
+			u, err = s.Create(ctx, attrs)
+			s.idempotency.finish(key, entry, u, err)
+			return u, false, err
+		}
+		if entry.fingerprint != fingerprint {
+			return user.User{}, false, fmt.Errorf("%w: idempotency key reused with different attributes", ErrInvalidInput)
+		}
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return user.User{}, false, ctx.Err()
+		}
+		if entry.err == nil {
+			return entry.user, true, nil
+		}
+		// The original attempt failed and was forgotten; try again.
+	}
+}
+
+// grpcIdempotencyKey prefers the request field over the metadata entry.
+func grpcIdempotencyKey(ctx context.Context, req *userpb.CreateUserRequest) string {
+	if key := req.GetIdempotencyKey(); key != "" {
+		return key
+	}
+	if values := metadata.ValueFromIncomingContext(ctx, idempotencyMetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// streamIdempotencyKey returns the idempotency key of a create command on
+// StreamUsers. A key set on the command is used as is. The stream's metadata
+// entry covers every command on it, so each create gets its own key from
+// that entry and the command's request_id, and a retried stream replays the
+// creates it already made. Without a request_id the key would be shared by
+// unrelated creates, so such a command is invalid.
+func streamIdempotencyKey(ctx context.Context, cmd *userpb.UserCommand) (string, error) {
+	if key := cmd.GetCreate().GetIdempotencyKey(); key != "" {
+		return key, nil
+	}
+	values := metadata.ValueFromIncomingContext(ctx, idempotencyMetadataKey)
+	if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+		return "", nil
+	}
+	if cmd.GetRequestId() == "" {
+		return "", fmt.Errorf("%w: create commands on a stream with an idempotency key need a request_id", ErrInvalidInput)
+	}
+	return strings.TrimSpace(values[0]) + "/" + cmd.GetRequestId(), nil
+}
+
+// idempotencyCache remembers successful creates for a fixed window. Entries
+// expire in insertion order, so a FIFO queue is enough to prune them. With
+// maxKeys set, the oldest finished entries are also dropped once that many
+// keys are held; creates still in flight are never dropped.
+type idempotencyCache struct {
+	window  time.Duration
+	maxKeys int
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	queue   []expiringKey
+}
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	// done is closed once user and err are set.
+	done chan struct{}
+	user user.User
+	err  error
+}
+
+type expiringKey struct {
+	key     string
+	entry   *idempotencyEntry
+	expires time.Time
+}
+
+func newIdempotencyCache(window time.Duration, maxKeys int) *idempotencyCache {
+	if window <= 0 {
+		return nil
+	}
+	return &idempotencyCache{
+		window:  window,
+		maxKeys: maxKeys,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// begin returns the entry for key, creating it if needed. owner reports
+// whether the caller created it and must finish it.
+func (c *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte) (entry *idempotencyEntry, owner bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pruneLocked(time.Now())
+	if entry, ok := c.entries[key]; ok {
+		return entry, false
+	}
+	entry = &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	c.entries[key] = entry
+	return entry, true
+}
+
+func (c *idempotencyCache) finish(key string, entry *idempotencyEntry, u user.User, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.user, entry.err = u, err
+	if err != nil {
+		delete(c.entries, key)
+	} else {
+		c.queue = append(c.queue, expiringKey{key: key, entry: entry, expires: time.Now().Add(c.window)})
+	}
+	close(entry.done)
+}
+
+func (c *idempotencyCache) pruneLocked(now time.Time) {
+	n := 0
+	for n < len(c.queue) && (!now.Before(c.queue[n].expires) || c.fullLocked()) {
+		if c.entries[c.queue[n].key] == c.queue[n].entry {
+			delete(c.entries, c.queue[n].key)
+		}
+		n++
+	}
+	if n > 0 {
+		c.queue = append(c.queue[:0], c.queue[n:]...)
+	}
+}
+
+// fullLocked reports whether no key can be added without going over maxKeys.
+func (c *idempotencyCache) fullLocked() bool {
+	return c.maxKeys > 0 && len(c.entries) >= c.maxKeys
+}
+
+// fingerprintAttributes hashes attrs so a reused key can be told apart from
+// a genuine retry without keeping the whole request. The expiry is left out:
+// a retried create with a TTL computes it anew, and is still a retry.
+func fingerprintAttributes(attrs user.Attributes) [sha256.Size]byte {
+	h := sha256.New()
+	writeField := func(b []byte) {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+		h.Write(n[:])
+		h.Write(b)
+	}
+	for _, field := range []string{attrs.Name, attrs.Email, attrs.Phone, attrs.Address, attrs.Bio} {
+		writeField([]byte(field))
+	}
+	writeField([]byte(fmt.Sprint(len(attrs.Tags))))
+	for _, tag := range attrs.Tags {
+		writeField([]byte(tag))
+	}
+	writeField(attrs.Avatar)
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+func TestCreateIdempotentReplays(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, Options{IdempotencyWindow: time.Minute})
+	attrs := user.Attributes{Name: "Ann", Email: "ann@example.com"}
+
+	first, replayed, err := svc.CreateIdempotent(ctx, "key-1", attrs)
+	if err != nil || replayed {
+		t.Fatalf("first create: replayed=%t, err=%v", replayed, err)
+	}
+	again, replayed, err := svc.CreateIdempotent(ctx, " key-1 ", attrs)
+	if err != nil || !replayed || again.ID != first.ID {
+		t.Fatalf("retry: user %s, replayed=%t, err=%v; want a replay of %s", again.ID, replayed, err, first.ID)
+	}
+
+	other := user.Attributes{Name: "Bob", Email: "bob@example.com"}
+	if _, _, err := svc.CreateIdempotent(ctx, "key-1", other); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("reused key: err = %v, want ErrInvalidInput", err)
+	}
+	if _, _, err := svc.CreateIdempotent(ctx, "key-2", other); err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+	users, _, total, err := svc.store.ListAfter(0, 10, user.Filter{})
+	if err != nil || total != 2 {
+		t.Fatalf("store holds %d users (%v), want 2", len(users), err)
+	}
+}
+
+func TestCreateIdempotentForgetsFailures(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, Options{IdempotencyWindow: time.Minute})
+	if _, err := svc.Create(ctx, user.Attributes{Name: "Ann", Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	taken := user.Attributes{Name: "Ann", Email: "ann@example.com"}
+	if _, _, err := svc.CreateIdempotent(ctx, "key", taken); !errors.Is(err, user.ErrEmailTaken) {
+		t.Fatalf("err = %v, want ErrEmailTaken", err)
+	}
+	fixed := user.Attributes{Name: "Ann", Email: "ann2@example.com"}
+	if _, replayed, err := svc.CreateIdempotent(ctx, "key", fixed); err != nil || replayed {
+		t.Fatalf("retry after failure: replayed=%t, err=%v", replayed, err)
+	}
+}
+
+func TestCreateIdempotentDisabled(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, Options{})
+	attrs := user.Attributes{Name: "Ann", Email: "ann@example.com"}
+	if _, _, err := svc.CreateIdempotent(ctx, "key", attrs); !errors.Is(err, ErrInvalidInput) {
+		t.Fatalf("key without a window: err = %v, want ErrInvalidInput", err)
+	}
+	if _, _, err := svc.CreateIdempotent(ctx, "", attrs); err != nil {
+		t.Fatalf("no key: %v", err)
+	}
+}
+
+func TestIdempotencyCacheBounds(t *testing.T) {
+	fingerprint := fingerprintAttributes(user.Attributes{Name: "Ann"})
+	remember := func(c *idempotencyCache, key string) {
+		t.Helper()
+		entry, owner := c.begin(key, fingerprint)
+		if !owner {
+			t.Fatalf("%s is already remembered", key)
+		}
+		c.finish(key, entry, user.User{ID: key}, nil)
+	}
+
+	c := newIdempotencyCache(time.Minute, 2)
+	for _, key := range []string{"a", "b", "c"} {
+		remember(c, key)
+	}
+	if _, ok := c.entries["a"]; ok || len(c.entries) != 2 {
+		t.Fatalf("remembered %d keys, a included: %t; want b and c", len(c.entries), ok)
+	}
+
+	c.mu.Lock()
+	c.pruneLocked(time.Now().Add(2 * time.Minute))
+	c.mu.Unlock()
+	if len(c.entries) != 0 || len(c.queue) != 0 {
+		t.Fatalf("%d keys outlived the window", len(c.entries))
+	}
+}
+
+func TestGRPCIdempotencyKeys(t *testing.T) {
+	svc := newTestService(t, Options{IdempotencyWindow: time.Minute})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(idempotencyMetadataKey, "stream-key"))
+	create := func(id, email string) *userpb.UserCommandResult {
+		return svc.execute(ctx, &userpb.UserCommand{
+			RequestId: id,
+			Command:   &userpb.UserCommand_Create{Create: &userpb.CreateUserRequest{Name: "User", Email: email}},
+		})
+	}
+
+	first, second := create("1", "one@example.com"), create("2", "two@example.com")
+	if first.GetCode() != 0 || second.GetCode() != 0 || first.GetUser().GetId() == second.GetUser().GetId() {
+		t.Fatalf("creates sharing the stream key: %v, %v", first, second)
+	}
+	if retry := create("1", "one@example.com"); retry.GetUser().GetId() != first.GetUser().GetId() {
+		t.Fatalf("retried command created %v, want a replay of %s", retry.GetUser(), first.GetUser().GetId())
+	}
+	if unnamed := create("", "three@example.com"); unnamed.GetCode() == 0 {
+		t.Fatal("create without a request_id on a keyed stream succeeded")
+	}
+
+	// The unary RPC applies the metadata key to its single create.
+	resp, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "User", Email: "unary@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := svc.CreateUser(ctx, &userpb.CreateUserRequest{Name: "User", Email: "unary@example.com"})
+	if err != nil || again.GetUser().GetId() != resp.GetUser().GetId() {
+		t.Fatalf("unary retry: %v, %v; want a replay of %s", again.GetUser(), err, resp.GetUser().GetId())
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+const (
+	importFlushSize = 256
+	maxImportErrors = 100
+	exportPageSize  = 256
+)
+
+// ImportResult summarizes an import. Errors holds at most the first
+// maxImportErrors rejections.
+type ImportResult struct {
+	Accepted int64
+	Rejected int64
+	Errors   []ImportError
+}
+
+type ImportError struct {
+	Index int64
+	Err   error
+}
+
+// Importer validates records one at a time and stores accepted ones in
+// batches, so arbitrarily large imports never have to be held in memory.
+type Importer struct {
+	svc     *Service
+	pending []user.Attributes
+	indices []int64
+	index   int64
+	result  ImportResult
+}
+
+func (s *Service) NewImporter(_ context.Context) *Importer {
+	return &Importer{
+		svc:     s,
+		pending: make([]user.Attributes, 0, importFlushSize),
+		indices: make([]int64, 0, importFlushSize),
+	}
+}
+
+// Add validates attrs and queues it for storage, or records a rejection.
+func (im *Importer) Add(attrs user.Attributes) {
+	clean := normalizeAttributes(attrs)
+	if err := im.svc.validatePayload(clean); err != nil {
+		im.Reject(err)
+		return
+	}
+	im.pending = append(im.pending, clean)
+	im.indices = append(im.indices, im.index)
+	im.index++
+	if len(im.pending) == importFlushSize {
+		im.flush()
+	}
+}
+
+// Reject records a record the transport could not decode.
+func (im *Importer) Reject(err error) {
+	im.rejectAt(im.index, err)
+	im.index++
+}
+
+func (im *Importer) rejectAt(index int64, err error) {
+	im.result.Rejected++
+	if len(im.result.Errors) < maxImportErrors {
+		im.result.Errors = append(im.result.Errors, ImportError{Index: index, Err: err})
+	}
+}
+
+// Finish stores any queued records and returns the totals.
+func (im *Importer) Finish() ImportResult {
+	im.flush()
+	return im.result
+}
+
+func (im *Importer) flush() {
+	if len(im.pending) == 0 {
+		return
+	}
+	for i, item := range im.svc.store.CreateBatch(im.pending) {
+		if item.Err != nil {
+			im.rejectAt(im.indices[i], item.Err)
+			continue
+		}
+		im.result.Accepted++
+	}
+	im.pending = im.pending[:0]
+	im.indices = im.indices[:0]
+}
+
+// Export calls fn for every live user in ID order, stopping at the first
+// error. Soft-deleted users are left out, as an import would bring them
+// back as live users, and so are expired ones.
+func (s *Service) Export(ctx context.Context, fn func(user.User) error) error {
+	return s.eachUser(ctx, user.Filter{}, fn)
+}
+
+// eachUser calls fn for every user matching filter in ID order. It reads
+// the store a page at a time, so exports never hold every user in memory.
+func (s *Service) eachUser(ctx context.Context, filter user.Filter, fn func(user.User) error) error {
+	var after int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		users, next, _, err := s.store.ListAfter(after, exportPageSize, filter)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		after = next
+	}
+}
+
+func (s *Service) ImportUsers(stream userpb.UserService_ImportUsersServer) error {
+	importer := s.NewImporter(stream.Context())
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		attrs, err := createRequestAttributes(req)
+		if err != nil {
+			importer.Reject(err)
+			continue
+		}
+		importer.Add(attrs)
+	}
+
+	result := importer.Finish()
+	resp := &userpb.ImportUsersResponse{
+		Accepted: result.Accepted,
+		Rejected: result.Rejected,
+		Errors:   make([]*userpb.ImportError, len(result.Errors)),
+	}
+	for i, e := range result.Errors {
+		resp.Errors[i] = &userpb.ImportError{Index: e.Index, Message: e.Err.Error()}
+	}
+	return stream.SendAndClose(resp)
+}
+
+func (s *Service) ExportUsers(_ *userpb.ExportUsersRequest, stream userpb.UserService_ExportUsersServer) error {
+	err := s.Export(stream.Context(), func(u user.User) error {
+		return stream.Send(toProto(u))
+	})
+	if err != nil {
+		return serviceError(err)
+	}
+	return nil
+}
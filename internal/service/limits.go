@@ -0,0 +1,63 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"golang-grpc/internal/user"
+)
+
+var (
+	ErrTooLarge = errors.New("user payload too large")
+)
+
+// Limits caps the size of the attributes accepted by the service, so both
+// transports reject oversized users the same way. Zero disables a check.
+type Limits struct {
+	MaxAvatarBytes int
+	MaxTags        int
+	MaxFieldLength int
+	MaxBatchSize   int
+	// MaxPageSize clamps the page size requested from List.
+	MaxPageSize int
+}
+
+func (l Limits) checkBatch(n int) error {
+	if l.MaxBatchSize > 0 && n > l.MaxBatchSize {
+		return fmt.Errorf("%w: batch exceeds %d items", ErrTooLarge, l.MaxBatchSize)
+	}
+	return nil
+}
+
+func (l Limits) check(attrs user.Attributes) error {
+	if l.MaxAvatarBytes > 0 && len(attrs.Avatar) > l.MaxAvatarBytes {
+		return fmt.Errorf("%w: avatar exceeds %d bytes", ErrTooLarge, l.MaxAvatarBytes)
+	}
+	if l.MaxTags > 0 && len(attrs.Tags) > l.MaxTags {
+		return fmt.Errorf("%w: more than %d tags", ErrTooLarge, l.MaxTags)
+	}
+	if l.MaxFieldLength <= 0 {
+		return nil
+	}
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"name", attrs.Name},
+		{"email", attrs.Email},
+		{"phone", attrs.Phone},
+		{"address", attrs.Address},
+		{"bio", attrs.Bio},
+	}
+	for _, f := range fields {
+		if len(f.value) > l.MaxFieldLength {
+			return fmt.Errorf("%w: %s exceeds %d bytes", ErrTooLarge, f.name, l.MaxFieldLength)
+		}
+	}
+	for _, tag := range attrs.Tags {
+		if len(tag) > l.MaxFieldLength {
+			return fmt.Errorf("%w: tag exceeds %d bytes", ErrTooLarge, l.MaxFieldLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang-grpc/internal/user"
+)
+
+// Names of the user fields a Patch can touch. They match both the JSON keys
+// and the proto field names, so field mask paths and merge patch keys share
+// one vocabulary.
+const (
+	FieldName    = "name"
+	FieldEmail   = "email"
+	FieldPhone   = "phone"
+	FieldAddress = "address"
+	FieldBio     = "bio"
+	FieldTags    = "tags"
+	FieldAvatar  = "avatar"
+	// FieldExpiresAt is the expiry; patching it to null makes the user
+	// permanent.
+	FieldExpiresAt = "expires_at"
+)
+
+// Patch is a partial update: only the fields listed in Fields are copied
+// from Attrs, everything else keeps its stored value. A listed field with a
+// zero value clears it.
+type Patch struct {
+	Fields []string
+	Attrs  user.Attributes
+}
+
+// Patch applies p to user id. Only touched fields are validated, so a patch
+// that changes the phone number never trips over the stored avatar. A patch
+// without fields returns the user unchanged. expectedVersion works as in
+// Update.
+func (s *Service) Patch(ctx context.Context, id string, p Patch, expectedVersion int64) (user.User, error) {
+	if err := validateIdentifier(id); err != nil {
+		return user.User{}, err
+	}
+	if len(p.Fields) == 0 {
+		u, err := s.Get(ctx, id)
+		if err == nil && expectedVersion != 0 && u.Version != expectedVersion {
+			return user.User{}, user.ErrVersionMismatch
+		}
+		return u, err
+	}
+
+	clean := normalizeAttributes(p.Attrs)
+	var touched user.Attributes
+	for _, field := range p.Fields {
+		if err := copyField(&touched, clean, field); err != nil {
+			return user.User{}, err
+		}
+	}
+	if err := s.validatePatch(p.Fields, touched); err != nil {
+		return user.User{}, err
+	}
+
+	return s.store.Modify(id, expectedVersion, func(current user.Attributes) (user.Attributes, error) {
+		for _, field := range p.Fields {
+			// Fields were checked above, so copyField cannot fail here.
+			_ = copyField(&current, touched, field)
+		}
+		return current, nil
+	})
+}
+
+func (s *Service) validatePatch(fields []string, touched user.Attributes) error {
+	for _, field := range fields {
+		switch field {
+		case FieldName:
+			if touched.Name == "" {
+				return fmt.Errorf("%w: name is required", ErrInvalidInput)
+			}
+		case FieldEmail:
+			if touched.Email == "" || !strings.Contains(touched.Email, "@") {
+				return fmt.Errorf("%w: email must contain '@'", ErrInvalidInput)
+			}
+		case FieldExpiresAt:
+			if err := checkExpiry(touched.ExpiresAt); err != nil {
+				return err
+			}
+		}
+	}
+	// Untouched fields are zero in touched, so only the patched values are
+	// held against the limits.
+	return s.limits.check(touched)
+}
+
+func copyField(dst *user.Attributes, src user.Attributes, field string) error {
+	switch field {
+	case FieldName:
+		dst.Name = src.Name
+	case FieldEmail:
+		dst.Email = src.Email
+	case FieldPhone:
+		dst.Phone = src.Phone
+	case FieldAddress:
+		dst.Address = src.Address
+	case FieldBio:
+		dst.Bio = src.Bio
+	case FieldTags:
+		dst.Tags = src.Tags
+	case FieldAvatar:
+		dst.Avatar = src.Avatar
+	case FieldExpiresAt:
+		dst.ExpiresAt = src.ExpiresAt
+	default:
+		return fmt.Errorf("%w: unknown field %q", ErrInvalidInput, field)
+	}
+	return nil
+}
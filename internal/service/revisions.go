@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// Revisions returns the kept versions of user id, newest first and the
+// current one included.
+func (s *Service) Revisions(_ context.Context, id string) ([]user.Revision, error) {
+	if err := validateIdentifier(id); err != nil {
+		return nil, err
+	}
+	return s.store.Revisions(id)
+}
+
+// Revision returns version of user id, if it is still kept.
+func (s *Service) Revision(ctx context.Context, id string, version int64) (user.Revision, error) {
+	if version < 1 {
+		return user.Revision{}, fmt.Errorf("%w: version must be positive", ErrInvalidInput)
+	}
+	revs, err := s.Revisions(ctx, id)
+	if err != nil {
+		return user.Revision{}, err
+	}
+	for _, rev := range revs {
+		if rev.Version == version {
+			return rev, nil
+		}
+	}
+	return user.Revision{}, fmt.Errorf("%w: user %s has no kept version %d", ErrRevisionNotFound, id, version)
+}
+
+func (s *Service) ListUserRevisions(ctx context.Context, req *userpb.ListUserRevisionsRequest) (*userpb.ListUserRevisionsResponse, error) {
+	revs, err := s.Revisions(ctx, strings.TrimSpace(req.GetId()))
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	resp := &userpb.ListUserRevisionsResponse{Revisions: make([]*userpb.UserRevision, len(revs))}
+	for i, rev := range revs {
+		resp.Revisions[i] = revisionToProto(rev)
+	}
+	return resp, nil
+}
+
+func (s *Service) GetUserRevision(ctx context.Context, req *userpb.GetUserRevisionRequest) (*userpb.UserRevision, error) {
+	rev, err := s.Revision(ctx, strings.TrimSpace(req.GetId()), req.GetVersion())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return revisionToProto(rev), nil
+}
+
+func revisionToProto(rev user.Revision) *userpb.UserRevision {
+	return &userpb.UserRevision{User: toProto(rev.User), ChangedFields: rev.Changed}
+}
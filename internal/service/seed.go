@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+const (
+	maxSeedUsers = 1_000_000
+	seedBatch    = 256
+	seedDomain   = "seed.example"
+)
+
+var seedWords = []string{"lorem", "ipsum", "dolor", "sit", "amet", "transport", "benchmark", "payload", "seed", "user"}
+
+// SeedOptions describes the users Seed generates. Tags, AvatarBytes and
+// BioBytes size each user's payload and must fit within the service limits.
+// EmailPrefix makes the generated emails unique; it defaults to a value
+// derived from the current time.
+type SeedOptions struct {
+	Count       int
+	Tags        int
+	AvatarBytes int
+	BioBytes    int
+	EmailPrefix string
+}
+
+// SeedResult counts the created users and those rejected because their
+// email was taken. FirstID and LastID are the lowest and highest created
+// IDs, or empty if none was created.
+type SeedResult struct {
+	Created int
+	Failed  int
+	FirstID string
+	LastID  string
+}
+
+// Reset removes every user and reports how many there were.
+func (a *Admin) Reset(_ context.Context) (int, error) {
+	return a.svc.store.Reset()
+}
+
+// Seed creates opts.Count generated users in batches.
+func (a *Admin) Seed(ctx context.Context, opts SeedOptions) (SeedResult, error) {
+	if opts.Count < 1 || opts.Count > maxSeedUsers {
+		return SeedResult{}, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidInput, maxSeedUsers)
+	}
+	if opts.Tags < 0 || opts.AvatarBytes < 0 || opts.BioBytes < 0 {
+		return SeedResult{}, fmt.Errorf("%w: payload sizes must not be negative", ErrInvalidInput)
+	}
+	prefix := strings.TrimSpace(opts.EmailPrefix)
+	if prefix == "" {
+		prefix = "seed-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	// Names, emails and addresses grow with the index, so the last user is
+	// the largest; checking it first rejects most bad options before
+	// anything is created.
+	if err := a.svc.validatePayload(seedAttributes(prefix, opts.Count-1, opts)); err != nil {
+		return SeedResult{}, err
+	}
+
+	var (
+		result  SeedResult
+		first   int64
+		last    int64
+		attrs   = make([]user.Attributes, 0, seedBatch)
+		seedErr error
+	)
+	flush := func() {
+		for _, item := range a.svc.store.CreateBatch(attrs) {
+			if item.Err != nil {
+				if !errors.Is(item.Err, user.ErrEmailTaken) {
+					seedErr = item.Err
+				}
+				result.Failed++
+				continue
+			}
+			n, _ := strconv.ParseInt(item.User.ID, 10, 64)
+			if result.Created == 0 || n < first {
+				first = n
+			}
+			last = max(last, n)
+			result.Created++
+		}
+		attrs = attrs[:0]
+	}
+	for i := 0; i < opts.Count && seedErr == nil; i++ {
+		// Bios start at a different word for every user, so their lengths
+		// vary; each user is still validated like any other create.
+		u := seedAttributes(prefix, i, opts)
+		if err := a.svc.validatePayload(u); err != nil {
+			seedErr = err
+			break
+		}
+		attrs = append(attrs, u)
+		if len(attrs) == seedBatch {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			flush()
+		}
+	}
+	if seedErr == nil {
+		flush()
+	}
+	if result.Created > 0 {
+		result.FirstID, result.LastID = strconv.FormatInt(first, 10), strconv.FormatInt(last, 10)
+	}
+	return result, seedErr
+}
+
+// seedAttributes generates user i. Bios are made of whole words and tags
+// cycle through a small set, so seeded users are useful for filtered lists.
+func seedAttributes(prefix string, i int, opts SeedOptions) user.Attributes {
+	attrs := user.Attributes{
+		Name:    fmt.Sprintf("Seed User %d", i),
+		Email:   fmt.Sprintf("%s-%d@%s", prefix, i, seedDomain),
+		Phone:   fmt.Sprintf("+1-555-%04d", i%10000),
+		Address: fmt.Sprintf("%d Seed Street", i+1),
+	}
+
+	var bio strings.Builder
+	for j := i; bio.Len() < opts.BioBytes; j++ {
+		if bio.Len() > 0 {
+			bio.WriteByte(' ')
+		}
+		bio.WriteString(seedWords[j%len(seedWords)])
+	}
+	attrs.Bio = bio.String()
+	if len(attrs.Bio) > opts.BioBytes {
+		attrs.Bio = strings.TrimSpace(attrs.Bio[:opts.BioBytes])
+	}
+
+	if opts.Tags > 0 {
+		attrs.Tags = make([]string, opts.Tags)
+		for j := range attrs.Tags {
+			attrs.Tags[j] = fmt.Sprintf("tag-%02d", (i+j)%100)
+		}
+	}
+	if opts.AvatarBytes > 0 {
+		attrs.Avatar = make([]byte, opts.AvatarBytes)
+		for j := range attrs.Avatar {
+			attrs.Avatar[j] = byte(i + j*13)
+		}
+	}
+	return attrs
+}
+
+func (a *Admin) ResetStore(ctx context.Context, _ *userpb.ResetStoreRequest) (*userpb.ResetStoreResponse, error) {
+	n, err := a.Reset(ctx)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &userpb.ResetStoreResponse{Removed: int64(n)}, nil
+}
+
+func (a *Admin) SeedUsers(ctx context.Context, req *userpb.SeedUsersRequest) (*userpb.SeedUsersResponse, error) {
+	result, err := a.Seed(ctx, SeedOptions{
+		Count:       int(req.GetCount()),
+		Tags:        int(req.GetTags()),
+		AvatarBytes: int(req.GetAvatarBytes()),
+		BioBytes:    int(req.GetBioBytes()),
+		EmailPrefix: req.GetEmailPrefix(),
+	})
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &userpb.SeedUsersResponse{
+		Created: int64(result.Created),
+		Failed:  int64(result.Failed),
+		FirstId: result.FirstID,
+		LastId:  result.LastID,
+	}, nil
+}
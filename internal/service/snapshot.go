@@ -0,0 +1,235 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protodelim"
+
+	"golang-grpc/internal/user"
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// SnapshotFormat selects the encoding of a snapshot file.
+type SnapshotFormat string
+
+const (
+	// SnapshotJSON writes one JSON user per line, as served over HTTP.
+	SnapshotJSON SnapshotFormat = "json"
+	// SnapshotProtobuf writes varint length-delimited userpb.User messages.
+	SnapshotProtobuf SnapshotFormat = "protobuf"
+)
+
+// RestoreMode selects how a snapshot is applied to the store.
+type RestoreMode string
+
+const (
+	// RestoreReplace makes the store hold exactly the snapshot's users.
+	RestoreReplace RestoreMode = "replace"
+	// RestoreMerge overwrites users with the same ID and keeps the rest.
+	RestoreMerge RestoreMode = "merge"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// ExportSnapshot writes every unexpired user, soft-deleted ones included, to
+// the file at path, replacing it atomically, and returns the number of users
+// written. An empty format is inferred from the file extension.
+func (s *Service) ExportSnapshot(ctx context.Context, path string, format SnapshotFormat) (int, error) {
+	format, err := snapshotFormat(path, format)
+	if err != nil {
+		return 0, err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(dir, ".snapshot-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	encode := snapshotEncoder(w, format)
+	n := 0
+	err = s.eachUser(ctx, user.Filter{ShowDeleted: true}, func(u user.User) error {
+		n++
+		return encode(u)
+	})
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(f.Name(), path)
+}
+
+// ImportSnapshot loads the users in the file at path with their IDs,
+// versions and timestamps. The whole file is decoded before the store is
+// touched, so a malformed file changes nothing; users that fail validation
+// or conflict with another user's email are rejected individually.
+func (s *Service) ImportSnapshot(_ context.Context, path string, format SnapshotFormat, mode RestoreMode) (ImportResult, error) {
+	if mode != RestoreReplace && mode != RestoreMerge {
+		return ImportResult{}, fmt.Errorf("%w: restore mode must be %q or %q", ErrInvalidInput, RestoreReplace, RestoreMerge)
+	}
+	format, err := snapshotFormat(path, format)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ImportResult{}, fmt.Errorf("%w: %s", ErrSnapshotNotFound, path)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer f.Close()
+
+	users, err := decodeSnapshot(bufio.NewReader(f), format)
+	if err != nil {
+		return ImportResult{}, fmt.Errorf("%w: %s: %v", ErrInvalidInput, path, err)
+	}
+
+	var (
+		result  ImportResult
+		valid   = make([]user.User, 0, len(users))
+		indices = make([]int64, 0, len(users))
+	)
+	reject := func(index int64, err error) {
+		result.Rejected++
+		if len(result.Errors) < maxImportErrors {
+			result.Errors = append(result.Errors, ImportError{Index: index, Err: err})
+		}
+	}
+	for i, u := range users {
+		if err := s.validateSnapshotUser(u); err != nil {
+			reject(int64(i), err)
+			continue
+		}
+		valid = append(valid, u)
+		indices = append(indices, int64(i))
+	}
+	for i, item := range s.store.Restore(valid, mode == RestoreReplace) {
+		if item.Err != nil {
+			reject(indices[i], item.Err)
+			continue
+		}
+		result.Accepted++
+	}
+	return result, nil
+}
+
+func (s *Service) validateSnapshotUser(u user.User) error {
+	if err := validateIdentifier(u.ID); err != nil {
+		return err
+	}
+	if u.Version < 1 {
+		return fmt.Errorf("%w: version must be positive", ErrInvalidInput)
+	}
+	return s.validatePayload(u.Attributes)
+}
+
+// snapshotFormat checks format, or infers it from the extension of path.
+func snapshotFormat(path string, format SnapshotFormat) (SnapshotFormat, error) {
+	switch format {
+	case SnapshotJSON, SnapshotProtobuf:
+		return format, nil
+	case "":
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".pb", ".binpb":
+			return SnapshotProtobuf, nil
+		default:
+			return SnapshotJSON, nil
+		}
+	default:
+		return "", fmt.Errorf("%w: snapshot format must be %q or %q", ErrInvalidInput, SnapshotJSON, SnapshotProtobuf)
+	}
+}
+
+// snapshotEncoder returns a function that writes one user to w in format.
+func snapshotEncoder(w io.Writer, format SnapshotFormat) func(user.User) error {
+	if format == SnapshotProtobuf {
+		return func(u user.User) error {
+			_, err := protodelim.MarshalTo(w, toProto(u))
+			return err
+		}
+	}
+	enc := json.NewEncoder(w)
+	return func(u user.User) error {
+		return enc.Encode(u)
+	}
+}
+
+func decodeSnapshot(r *bufio.Reader, format SnapshotFormat) ([]user.User, error) {
+	var users []user.User
+	if format == SnapshotProtobuf {
+		opts := protodelim.UnmarshalOptions{MaxSize: -1}
+		for {
+			var pb userpb.User
+			err := opts.UnmarshalFrom(r, &pb)
+			if errors.Is(err, io.EOF) {
+				return users, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("user %d: %w", len(users), err)
+			}
+			users = append(users, fromProto(&pb))
+		}
+	}
+	dec := json.NewDecoder(r)
+	for {
+		var u user.User
+		err := dec.Decode(&u)
+		if errors.Is(err, io.EOF) {
+			return users, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("user %d: %w", len(users), err)
+		}
+		users = append(users, u)
+	}
+}
+
+// fromProto is the inverse of toProto.
+func fromProto(pb *userpb.User) user.User {
+	u := user.User{
+		ID:         pb.GetId(),
+		Attributes: protoToAttributes(pb.GetName(), pb.GetEmail(), pb.GetPhone(), pb.GetAddress(), pb.GetBio(), pb.GetTags(), pb.GetAvatar()),
+		Version:    pb.GetVersion(),
+	}
+	if pb.CreatedAt != nil {
+		u.CreatedAt = pb.GetCreatedAt().AsTime()
+	}
+	if pb.UpdatedAt != nil {
+		u.UpdatedAt = pb.GetUpdatedAt().AsTime()
+	}
+	if pb.DeletedAt != nil {
+		deletedAt := pb.GetDeletedAt().AsTime()
+		u.DeletedAt = &deletedAt
+	}
+	if pb.ExpiresAt != nil {
+		expiresAt := pb.GetExpiresAt().AsTime()
+		u.ExpiresAt = &expiresAt
+	}
+	return u
+}
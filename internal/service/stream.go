@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/grpc/status"
+
+	userpb "golang-grpc/pkg/gen/user/v1"
+)
+
+// StreamUsers executes CRUD commands in the order they arrive on a single
+// long-lived stream. A failed command is reported in its result and does
+// not end the stream. An idempotency key in the stream's metadata applies
+// to each create under its own request_id; see streamIdempotencyKey.
+func (s *Service) StreamUsers(stream userpb.UserService_StreamUsersServer) error {
+	ctx := stream.Context()
+	for {
+		cmd, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(s.execute(ctx, cmd)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Service) execute(ctx context.Context, cmd *userpb.UserCommand) *userpb.UserCommandResult {
+	var (
+		resp *userpb.UserResponse
+		err  error
+	)
+	switch c := cmd.GetCommand().(type) {
+	case *userpb.UserCommand_Create:
+		var key string
+		if key, err = streamIdempotencyKey(ctx, cmd); err != nil {
+			err = serviceError(err)
+			break
+		}
+		resp, err = s.createUser(ctx, c.Create, key)
+	case *userpb.UserCommand_Update:
+		resp, err = s.UpdateUser(ctx, c.Update)
+	case *userpb.UserCommand_Get:
+		resp, err = s.GetUser(ctx, c.Get)
+	case *userpb.UserCommand_Delete:
+		_, err = s.DeleteUser(ctx, c.Delete)
+	default:
+		err = serviceError(fmt.Errorf("%w: command is required", ErrInvalidInput))
+	}
+
+	st := status.Convert(err)
+	return &userpb.UserCommandResult{
+		RequestId: cmd.GetRequestId(),
+		Code:      int32(st.Code()),
+		Message:   st.Message(),
+		User:      resp.GetUser(),
+	}
+}
@@ -2,49 +2,72 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang-grpc/internal/user"
 	userpb "golang-grpc/pkg/gen/user/v1"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
 	ErrInvalidInput = errors.New("invalid user input")
 )
 
+// Options tunes the service. The zero value applies no limits and rejects
+// idempotency keys.
+type Options struct {
+	Limits Limits
+	// IdempotencyWindow is how long the outcome of a create is remembered
+	// under its idempotency key. IdempotencyMaxKeys, if positive, caps how
+	// many keys are remembered; past it the oldest are forgotten early.
+	IdempotencyWindow  time.Duration
+	IdempotencyMaxKeys int
+}
+
 type Service struct {
-	store *user.Store
+	store       user.Repository
+	limits      Limits
+	idempotency *idempotencyCache
 	userpb.UnimplementedUserServiceServer
 }
 
-func NewUserService(store *user.Store) *Service {
+func NewUserService(store user.Repository, opts Options) *Service {
 	return &Service{
-		store: store,
+		store:       store,
+		limits:      opts.Limits,
+		idempotency: newIdempotencyCache(opts.IdempotencyWindow, opts.IdempotencyMaxKeys),
 	}
 }
 
 func (s *Service) Create(_ context.Context, attrs user.Attributes) (user.User, error) {
 	clean := normalizeAttributes(attrs)
-	if err := validatePayload(clean); err != nil {
+	if err := s.validatePayload(clean); err != nil {
 		return user.User{}, err
 	}
-	return s.store.Create(clean), nil
+	return s.store.Create(clean)
 }
 
-func (s *Service) Update(_ context.Context, id string, attrs user.Attributes) (user.User, error) {
+// Update replaces every attribute of user id. A non-zero expectedVersion
+// makes the update conditional on the stored version.
+func (s *Service) Update(_ context.Context, id string, attrs user.Attributes, expectedVersion int64) (user.User, error) {
 	if err := validateIdentifier(id); err != nil {
 		return user.User{}, err
 	}
 	clean := normalizeAttributes(attrs)
-	if err := validatePayload(clean); err != nil {
+	if err := s.validatePayload(clean); err != nil {
 		return user.User{}, err
 	}
-	updated, err := s.store.Update(id, clean)
+	updated, err := s.store.Update(id, clean, expectedVersion)
 	if err != nil {
 		return user.User{}, err
 	}
@@ -55,33 +78,118 @@ func (s *Service) Get(_ context.Context, id string) (user.User, error) {
 	if err := validateIdentifier(id); err != nil {
 		return user.User{}, err
 	}
-	u, ok := s.store.Get(id)
-	if !ok {
-		return user.User{}, user.ErrNotFound
+	return s.store.Get(id)
+}
+
+// GetByEmail finds a user by email address, ignoring case.
+func (s *Service) GetByEmail(_ context.Context, email string) (user.User, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return user.User{}, fmt.Errorf("%w: email is required", ErrInvalidInput)
 	}
-	return u, nil
+	return s.store.GetByEmail(email)
 }
 
-func (s *Service) Delete(_ context.Context, id string) error {
+// Delete soft-deletes user id. expectedVersion works as in Update.
+func (s *Service) Delete(_ context.Context, id string, expectedVersion int64) error {
 	if err := validateIdentifier(id); err != nil {
 		return err
 	}
-	if ok := s.store.Delete(id); !ok {
-		return user.ErrNotFound
+	return s.store.Delete(id, expectedVersion)
+}
+
+// Undelete restores soft-deleted user id. expectedVersion works as in
+// Update.
+func (s *Service) Undelete(_ context.Context, id string, expectedVersion int64) (user.User, error) {
+	if err := validateIdentifier(id); err != nil {
+		return user.User{}, err
 	}
-	return nil
+	return s.store.Undelete(id, expectedVersion)
 }
 
-func (s *Service) List(_ context.Context) []user.User {
-	return s.store.List()
+// Purge permanently removes soft-deleted user id. expectedVersion works as
+// in Update.
+func (s *Service) Purge(_ context.Context, id string, expectedVersion int64) error {
+	if err := validateIdentifier(id); err != nil {
+		return err
+	}
+	return s.store.Purge(id, expectedVersion)
+}
+
+const defaultPageSize = 100
+
+// ListOptions selects one page of users in ID (creation) order. PageToken is
+// the NextPageToken of the previous page, or empty for the first page.
+// Filter is applied before paging, and TotalSize counts matching users.
+type ListOptions struct {
+	PageSize     int
+	PageToken    string
+	IncludeTotal bool
+	Filter       user.Filter
+}
+
+// ListPage is one page of users. NextPageToken is empty on the last page and
+// TotalSize is only set when requested.
+type ListPage struct {
+	Users         []user.User
+	NextPageToken string
+	TotalSize     int
+}
+
+func (s *Service) List(_ context.Context, opts ListOptions) (ListPage, error) {
+	if opts.PageSize < 0 {
+		return ListPage{}, fmt.Errorf("%w: page size must not be negative", ErrInvalidInput)
+	}
+	size := opts.PageSize
+	if size == 0 {
+		size = defaultPageSize
+	}
+	if s.limits.MaxPageSize > 0 && size > s.limits.MaxPageSize {
+		size = s.limits.MaxPageSize
+	}
+
+	after, err := decodePageToken(opts.PageToken)
+	if err != nil {
+		return ListPage{}, err
+	}
+
+	filter := normalizeFilter(opts.Filter)
+	if filter.BioQuery != "" && !user.HasWords(filter.BioQuery) {
+		return ListPage{}, fmt.Errorf("%w: bio_query must contain a letter or digit", ErrInvalidInput)
+	}
+
+	users, next, total, err := s.store.ListAfter(after, size, filter)
+	if err != nil {
+		return ListPage{}, err
+	}
+	page := ListPage{Users: users}
+	if next != 0 {
+		page.NextPageToken = encodePageToken(next)
+	}
+	if opts.IncludeTotal {
+		page.TotalSize = total
+	}
+	return page, nil
 }
 
 func (s *Service) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.UserResponse, error) {
-	attrs := protoToAttributes(req.GetName(), req.GetEmail(), req.GetPhone(), req.GetAddress(), req.GetBio(), req.GetTags(), req.GetAvatar())
-	u, err := s.Create(ctx, attrs)
+	return s.createUser(ctx, req, grpcIdempotencyKey(ctx, req))
+}
+
+func (s *Service) createUser(ctx context.Context, req *userpb.CreateUserRequest, idempotencyKey string) (*userpb.UserResponse, error) {
+	attrs, err := createRequestAttributes(req)
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	u, replayed, err := s.CreateIdempotent(ctx, idempotencyKey, attrs)
 	if err != nil {
 		return nil, serviceError(err)
 	}
+	if replayed {
+		// Streams have already sent their headers; the replay is then
+		// only visible through the unchanged user.
+		_ = grpc.SetHeader(ctx, metadata.Pairs(replayedMetadataKey, "true"))
+	}
 	return &userpb.UserResponse{User: toProto(u)}, nil
 }
 
@@ -90,12 +198,34 @@ func (s *Service) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*use
 	if err != nil {
 		return nil, serviceError(err)
 	}
+	if since := req.GetIfChangedSinceVersion(); since != 0 && u.Version == since {
+		return &userpb.UserResponse{NotModified: true}, nil
+	}
+	return &userpb.UserResponse{User: toProto(u)}, nil
+}
+
+func (s *Service) GetUserByEmail(ctx context.Context, req *userpb.GetUserByEmailRequest) (*userpb.UserResponse, error) {
+	u, err := s.GetByEmail(ctx, req.GetEmail())
+	if err != nil {
+		return nil, serviceError(err)
+	}
 	return &userpb.UserResponse{User: toProto(u)}, nil
 }
 
 func (s *Service) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UserResponse, error) {
 	attrs := protoToAttributes(req.GetName(), req.GetEmail(), req.GetPhone(), req.GetAddress(), req.GetBio(), req.GetTags(), req.GetAvatar())
-	u, err := s.Update(ctx, strings.TrimSpace(req.GetId()), attrs)
+	expiresAt, err := expiryFromProto(req.GetExpiresAt(), req.GetTtlSeconds())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	attrs.ExpiresAt = expiresAt
+	id := strings.TrimSpace(req.GetId())
+	var u user.User
+	if paths := req.GetUpdateMask().GetPaths(); len(paths) > 0 {
+		u, err = s.Patch(ctx, id, Patch{Fields: paths, Attrs: attrs}, req.GetExpectedVersion())
+	} else {
+		u, err = s.Update(ctx, id, attrs, req.GetExpectedVersion())
+	}
 	if err != nil {
 		return nil, serviceError(err)
 	}
@@ -103,44 +233,90 @@ func (s *Service) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest)
 }
 
 func (s *Service) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*emptypb.Empty, error) {
-	if err := s.Delete(ctx, strings.TrimSpace(req.GetId())); err != nil {
+	if err := s.Delete(ctx, strings.TrimSpace(req.GetId()), req.GetExpectedVersion()); err != nil {
+		return nil, serviceError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Service) UndeleteUser(ctx context.Context, req *userpb.UndeleteUserRequest) (*userpb.UserResponse, error) {
+	u, err := s.Undelete(ctx, strings.TrimSpace(req.GetId()), req.GetExpectedVersion())
+	if err != nil {
+		return nil, serviceError(err)
+	}
+	return &userpb.UserResponse{User: toProto(u)}, nil
+}
+
+func (s *Service) PurgeUser(ctx context.Context, req *userpb.PurgeUserRequest) (*emptypb.Empty, error) {
+	if err := s.Purge(ctx, strings.TrimSpace(req.GetId()), req.GetExpectedVersion()); err != nil {
 		return nil, serviceError(err)
 	}
 	return &emptypb.Empty{}, nil
 }
 
-func (s *Service) ListUsers(ctx context.Context, _ *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
-	users := s.List(ctx)
+func (s *Service) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	page, err := s.List(ctx, ListOptions{
+		PageSize:     int(req.GetPageSize()),
+		PageToken:    req.GetPageToken(),
+		IncludeTotal: req.GetIncludeTotal(),
+		Filter: user.Filter{
+			TagsAny:     req.GetTagsAny(),
+			TagsAll:     req.GetTagsAll(),
+			Email:       req.GetEmail(),
+			NamePrefix:  req.GetNamePrefix(),
+			BioQuery:    req.GetBioQuery(),
+			ShowDeleted: req.GetShowDeleted(),
+		},
+	})
+	if err != nil {
+		return nil, serviceError(err)
+	}
 	resp := &userpb.ListUsersResponse{
-		Users: make([]*userpb.User, 0, len(users)),
+		Users:         make([]*userpb.User, 0, len(page.Users)),
+		NextPageToken: page.NextPageToken,
+		TotalSize:     int32(page.TotalSize),
 	}
-	for _, u := range users {
+	for _, u := range page.Users {
 		resp.Users = append(resp.Users, toProto(u))
 	}
 	return resp, nil
 }
 
+// toProto shares the stored tags and avatar; marshalling only reads them.
 func toProto(u user.User) *userpb.User {
-	return &userpb.User{
-		Id:      u.ID,
-		Name:    u.Name,
-		Email:   u.Email,
-		Phone:   u.Phone,
-		Address: u.Address,
-		Bio:     u.Bio,
-		Tags:    append([]string(nil), u.Tags...),
-		Avatar:  append([]byte(nil), u.Avatar...),
+	pb := &userpb.User{
+		Id:        u.ID,
+		Name:      u.Name,
+		Email:     u.Email,
+		Phone:     u.Phone,
+		Address:   u.Address,
+		Bio:       u.Bio,
+		Tags:      u.Tags,
+		Avatar:    u.Avatar,
+		Version:   u.Version,
+		CreatedAt: timestamppb.New(u.CreatedAt),
+		UpdatedAt: timestamppb.New(u.UpdatedAt),
+	}
+	if u.DeletedAt != nil {
+		pb.DeletedAt = timestamppb.New(*u.DeletedAt)
 	}
+	if u.ExpiresAt != nil {
+		pb.ExpiresAt = timestamppb.New(*u.ExpiresAt)
+	}
+	return pb
 }
 
-func validatePayload(attrs user.Attributes) error {
+func (s *Service) validatePayload(attrs user.Attributes) error {
 	if attrs.Name == "" {
 		return fmt.Errorf("%w: name is required", ErrInvalidInput)
 	}
 	if attrs.Email == "" || !strings.Contains(attrs.Email, "@") {
 		return fmt.Errorf("%w: email must contain '@'", ErrInvalidInput)
 	}
-	return nil
+	if err := checkExpiry(attrs.ExpiresAt); err != nil {
+		return err
+	}
+	return s.limits.check(attrs)
 }
 
 func validateIdentifier(id string) error {
@@ -150,6 +326,29 @@ func validateIdentifier(id string) error {
 	return nil
 }
 
+// Page tokens are opaque to clients; they encode the last ID of the
+// previous page so pagination stays stable across inserts and deletes.
+const pageTokenPrefix = "after:"
+
+func encodePageToken(after int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(pageTokenPrefix + strconv.FormatInt(after, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil && strings.HasPrefix(string(raw), pageTokenPrefix) {
+		if after, err := strconv.ParseInt(strings.TrimPrefix(string(raw), pageTokenPrefix), 10, 64); err == nil && after > 0 {
+			return after, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: invalid page token", ErrInvalidInput)
+}
+
+// protoToAttributes shares tags and avatar with the decoded request, which
+// is not used again once the handler returns.
 func protoToAttributes(name, email, phone, address, bio string, tags []string, avatar []byte) user.Attributes {
 	return user.Attributes{
 		Name:    name,
@@ -157,8 +356,8 @@ func protoToAttributes(name, email, phone, address, bio string, tags []string, a
 		Phone:   phone,
 		Address: address,
 		Bio:     bio,
-		Tags:    append([]string(nil), tags...),
-		Avatar:  append([]byte(nil), avatar...),
+		Tags:    tags,
+		Avatar:  avatar,
 	}
 }
 
@@ -168,26 +367,69 @@ func normalizeAttributes(attrs user.Attributes) user.Attributes {
 	attrs.Phone = strings.TrimSpace(attrs.Phone)
 	attrs.Address = strings.TrimSpace(attrs.Address)
 	attrs.Bio = strings.TrimSpace(attrs.Bio)
-	if len(attrs.Tags) > 0 {
-		clean := attrs.Tags[:0]
-		for _, tag := range attrs.Tags {
-			tag = strings.TrimSpace(tag)
-			if tag != "" {
-				clean = append(clean, tag)
+	attrs.Tags = normalizeTags(attrs.Tags)
+	if attrs.ExpiresAt != nil {
+		at := attrs.ExpiresAt.UTC()
+		attrs.ExpiresAt = &at
+	}
+	return attrs
+}
+
+// normalizeTags trims tags and drops blank ones. The input may be shared,
+// so it is copied only when a tag actually changes.
+func normalizeTags(tags []string) []string {
+	for i, tag := range tags {
+		if trimmed := strings.TrimSpace(tag); trimmed != tag || trimmed == "" {
+			clean := make([]string, i, len(tags))
+			copy(clean, tags[:i])
+			for _, tag := range tags[i:] {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					clean = append(clean, tag)
+				}
 			}
+			return clean
 		}
-		attrs.Tags = clean
 	}
-	return attrs
+	return tags
+}
+
+// normalizeFilter drops blank values so that, for example, an empty tag in
+// a query string does not match nothing.
+func normalizeFilter(f user.Filter) user.Filter {
+	f.TagsAny = nonBlank(f.TagsAny)
+	f.TagsAll = nonBlank(f.TagsAll)
+	f.Email = strings.TrimSpace(f.Email)
+	f.NamePrefix = strings.TrimSpace(f.NamePrefix)
+	f.BioQuery = strings.TrimSpace(f.BioQuery)
+	return f
+}
+
+func nonBlank(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
 }
 
 func serviceError(err error) error {
 	switch {
 	case errors.Is(err, ErrInvalidInput):
 		return status.Error(codes.InvalidArgument, err.Error())
-	case errors.Is(err, user.ErrNotFound):
+	case errors.Is(err, ErrTooLarge), errors.Is(err, user.ErrStoreFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, user.ErrNotFound), errors.Is(err, ErrSnapshotNotFound), errors.Is(err, ErrRevisionNotFound):
 		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, user.ErrEmailTaken):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, user.ErrVersionMismatch), errors.Is(err, user.ErrNotDeleted):
+		return status.Error(codes.FailedPrecondition, err.Error())
 	default:
+		if st, ok := status.FromError(err); ok {
+			return st.Err()
+		}
 		return status.Error(codes.Internal, err.Error())
 	}
 }
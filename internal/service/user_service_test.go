@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang-grpc/internal/user"
+)
+
+func newTestService(t *testing.T, opts Options) *Service {
+	t.Helper()
+	store := user.NewStore(user.Options{})
+	t.Cleanup(func() { store.Close() })
+	return NewUserService(store, opts)
+}
+
+func createTestUsers(t *testing.T, svc *Service, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := range ids {
+		u, err := svc.Create(context.Background(), user.Attributes{
+			Name:  fmt.Sprintf("User %d", i),
+			Email: fmt.Sprintf("user-%d@example.com", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+func TestListPageTokens(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t, Options{})
+	ids := createTestUsers(t, svc, 7)
+
+	var got []string
+	opts := ListOptions{PageSize: 3, IncludeTotal: true}
+	for pages := 0; ; pages++ {
+		if pages > len(ids) {
+			t.Fatal("pagination does not terminate")
+		}
+		page, err := svc.List(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.TotalSize != len(ids) {
+			t.Fatalf("TotalSize = %d, want %d", page.TotalSize, len(ids))
+		}
+		for _, u := range page.Users {
+			got = append(got, u.ID)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		// Users created or deleted mid-listing show up, or stop showing up,
+		// on later pages without shifting the earlier ones.
+		if pages == 0 {
+			u, err := svc.Create(ctx, user.Attributes{Name: "Late", Email: "late@example.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.store.Delete(ids[3], 0); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(append(ids[:3], ids[4:]...), u.ID)
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	if fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Fatalf("listed %v, want %v", got, ids)
+	}
+}
+
+func TestListRejectsBadOptions(t *testing.T) {
+	svc := newTestService(t, Options{})
+	for name, opts := range map[string]ListOptions{
+		"negative page size": {PageSize: -1},
+		"garbage token":      {PageToken: "not-a-token"},
+		"foreign token":      {PageToken: "YWZ0ZXI6LTE"}, // "after:-1"
+		"wordless bio query": {Filter: user.Filter{BioQuery: " ?! "}},
+	} {
+		if _, err := svc.List(context.Background(), opts); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("%s: err = %v, want ErrInvalidInput", name, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package grpctransport
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"golang-grpc/internal/auth"
+)
+
+func clientIdentityUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(withClientIdentity(ctx, info.FullMethod), req)
+}
+
+func clientIdentityStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &identityStream{ServerStream: ss, ctx: withClientIdentity(ss.Context(), info.FullMethod)})
+}
+
+// withClientIdentity copies the verified certificate subject of the peer
+// into the request context so service methods can see who is calling.
+func withClientIdentity(ctx context.Context, method string) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return ctx
+	}
+	subject, ok := auth.PeerSubject(&tlsInfo.State)
+	if !ok {
+		return ctx
+	}
+	log.Printf("grpc %s client=%q", method, subject)
+	return auth.WithClientSubject(ctx, subject)
+}
+
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
@@ -1,16 +1,50 @@
 package grpctransport
 
 import (
+	"crypto/tls"
+
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor for grpc.UseCompressor
 	"google.golang.org/grpc/reflection"
 
 	userpb "golang-grpc/pkg/gen/user/v1"
 )
 
-// NewServer constructs a gRPC server and registers the user service.
-func NewServer(svc userpb.UserServiceServer) *grpc.Server {
-	server := grpc.NewServer()
+// Options tunes the gRPC server. The zero value serves plaintext.
+type Options struct {
+	TLSConfig *tls.Config
+	// MaxRecvMsgSize and MaxSendMsgSize override the gRPC defaults when set.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+	// Admin is registered alongside the user service when set.
+	Admin userpb.AdminServiceServer
+}
+
+// NewServer constructs a gRPC server and registers the user service, and the
+// admin service if one is given.
+func NewServer(svc userpb.UserServiceServer, opts Options) *grpc.Server {
+	var serverOpts []grpc.ServerOption
+	if opts.TLSConfig != nil {
+		serverOpts = append(serverOpts,
+			grpc.Creds(credentials.NewTLS(opts.TLSConfig)),
+			grpc.ChainUnaryInterceptor(clientIdentityUnaryInterceptor),
+			grpc.ChainStreamInterceptor(clientIdentityStreamInterceptor),
+		)
+	}
+
+	if opts.MaxRecvMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(opts.MaxRecvMsgSize))
+	}
+	if opts.MaxSendMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxSendMsgSize(opts.MaxSendMsgSize))
+	}
+
+	server := grpc.NewServer(serverOpts...)
 	userpb.RegisterUserServiceServer(server, svc)
+	if opts.Admin != nil {
+		userpb.RegisterAdminServiceServer(server, opts.Admin)
+	}
 	reflection.Register(server)
 	return server
 }
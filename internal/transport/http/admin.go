@@ -0,0 +1,116 @@
+package httptransport
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-grpc/internal/service"
+)
+
+// snapshotRequest names a snapshot file relative to the server's snapshot
+// directory. An empty format is inferred from the file extension.
+type snapshotRequest struct {
+	Path   string                 `json:"path"`
+	Format service.SnapshotFormat `json:"format"`
+	Mode   service.RestoreMode    `json:"mode"`
+}
+
+// snapshotAction serves POST /admin/snapshots:export and
+// POST /admin/snapshots:import.
+func (h *handler) snapshotAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":export":
+		h.exportSnapshot(c)
+	case ":import":
+		h.importSnapshot(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown snapshot method"})
+	}
+}
+
+func (h *handler) exportSnapshot(c *gin.Context) {
+	var payload snapshotRequest
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+	path, n, err := h.admin.Export(c.Request.Context(), payload.Path, payload.Format)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": path, "users": n})
+}
+
+func (h *handler) importSnapshot(c *gin.Context) {
+	var payload snapshotRequest
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+	result, err := h.admin.Import(c.Request.Context(), payload.Path, payload.Format, payload.Mode)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	errs := make([]importError, len(result.Errors))
+	for i, e := range result.Errors {
+		errs[i] = importError{Index: e.Index, Error: e.Err.Error()}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"restored": result.Accepted,
+		"rejected": result.Rejected,
+		"errors":   errs,
+	})
+}
+
+type seedRequest struct {
+	Count       int    `json:"count"`
+	Tags        int    `json:"tags"`
+	AvatarBytes int    `json:"avatar_bytes"`
+	BioBytes    int    `json:"bio_bytes"`
+	EmailPrefix string `json:"email_prefix"`
+}
+
+// storeAction serves POST /admin/store:reset and POST /admin/store:seed.
+func (h *handler) storeAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":reset":
+		h.resetStore(c)
+	case ":seed":
+		h.seedUsers(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown store method"})
+	}
+}
+
+func (h *handler) resetStore(c *gin.Context) {
+	n, err := h.admin.Reset(c.Request.Context())
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": n})
+}
+
+func (h *handler) seedUsers(c *gin.Context) {
+	var payload seedRequest
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+	result, err := h.admin.Seed(c.Request.Context(), service.SeedOptions{
+		Count:       payload.Count,
+		Tags:        payload.Tags,
+		AvatarBytes: payload.AvatarBytes,
+		BioBytes:    payload.BioBytes,
+		EmailPrefix: payload.EmailPrefix,
+	})
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	resp := gin.H{"created": result.Created, "failed": result.Failed}
+	if result.Created > 0 {
+		resp["first_id"], resp["last_id"] = result.FirstID, result.LastID
+	}
+	c.JSON(http.StatusOK, resp)
+}
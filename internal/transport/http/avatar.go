@@ -0,0 +1,33 @@
+package httptransport
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *handler) putAvatar(c *gin.Context) {
+	id := c.Param("id")
+	h.limitBody(c)
+	u, err := h.svc.PutAvatar(c.Request.Context(), id, c.Request.Body, c.Request.ContentLength, h.ifMatchVersion(c, id))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.Header("ETag", userETag(u))
+	c.JSON(http.StatusOK, gin.H{"id": id, "size": len(u.Avatar)})
+}
+
+// getAvatar serves the raw avatar bytes. http.ServeContent takes care of
+// Range, If-Range and HEAD requests.
+func (h *handler) getAvatar(c *gin.Context) {
+	avatar, err := h.svc.GetAvatar(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(avatar))
+}
@@ -0,0 +1,88 @@
+package httptransport
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-grpc/internal/service"
+	"golang-grpc/internal/user"
+)
+
+type batchCreateRequest struct {
+	Users []userPayload `json:"users"`
+}
+
+type batchIDsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type batchResult struct {
+	ID     string     `json:"id,omitempty"`
+	Status int        `json:"status"`
+	Error  string     `json:"error,omitempty"`
+	User   *user.User `json:"user,omitempty"`
+}
+
+func (h *handler) batchCreate(c *gin.Context) {
+	var payload batchCreateRequest
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+	attrs := make([]user.Attributes, len(payload.Users))
+	decodeErrs := make([]error, len(attrs))
+	for i, p := range payload.Users {
+		attrs[i], decodeErrs[i] = p.attributes()
+	}
+	results, err := h.svc.BatchCreate(c.Request.Context(), attrs, decodeErrs)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": toBatchResults(results, http.StatusCreated)})
+}
+
+func (h *handler) batchGet(c *gin.Context) {
+	var payload batchIDsRequest
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+	results, err := h.svc.BatchGet(c.Request.Context(), payload.IDs)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": toBatchResults(results, http.StatusOK)})
+}
+
+func (h *handler) batchDelete(c *gin.Context) {
+	var payload batchIDsRequest
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+	results, err := h.svc.BatchDelete(c.Request.Context(), payload.IDs)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": toBatchResults(results, http.StatusNoContent)})
+}
+
+// toBatchResults reports each item with the status code the equivalent
+// single-item request would have returned.
+func toBatchResults(results []service.BatchResult, success int) []batchResult {
+	out := make([]batchResult, len(results))
+	for i, r := range results {
+		out[i] = batchResult{ID: r.ID, Status: success}
+		if r.Err != nil {
+			out[i].Status = errorStatus(r.Err)
+			out[i].Error = r.Err.Error()
+			continue
+		}
+		if r.User.ID != "" {
+			u := r.User
+			out[i].User = &u
+		}
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package httptransport
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-grpc/internal/user"
+)
+
+// ETags combine the user ID and version. A version identifies one exact
+// state of a user, so the tags are strong; the ID keeps them distinct on
+// routes such as /users/by-email/:email, which can name different users
+// over time.
+func userETag(u user.User) string {
+	return `"` + u.ID + "." + strconv.FormatInt(u.Version, 10) + `"`
+}
+
+// writeUser sends u with its ETag.
+func writeUser(c *gin.Context, status int, u user.User) {
+	c.Header("ETag", userETag(u))
+	c.JSON(status, u)
+}
+
+// readUser answers a read of u, replying 304 without a body when the client
+// already holds the current version.
+func (h *handler) readUser(c *gin.Context, u user.User) {
+	c.Header("Cache-Control", h.cacheControl)
+	if etagMatches(c.GetHeader("If-None-Match"), userETag(u)) {
+		c.Header("ETag", userETag(u))
+		c.Status(http.StatusNotModified)
+		return
+	}
+	writeUser(c, http.StatusOK, u)
+}
+
+// cacheControl lets private caches keep users for maxAge. Users can change
+// at any time, so without a max age clients must revalidate every read.
+func cacheControl(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return "private, no-cache"
+	}
+	return "private, max-age=" + strconv.Itoa(int(maxAge/time.Second))
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison RFC 9110 prescribes for that header.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion turns an If-Match header into the version a write expects.
+// Without the header, or with "*", the write is unconditional (zero). The
+// header may list several tags, weak ones included; a tag that does not
+// name user id and a version is skipped. A single listed version is
+// expected as is, while for several the current version is looked up and
+// expected if it is listed. When no tag can match, an impossible version is
+// returned and the write fails its precondition.
+func (h *handler) ifMatchVersion(c *gin.Context, id string) int64 {
+	raw := strings.TrimSpace(c.GetHeader("If-Match"))
+	if raw == "" {
+		return 0
+	}
+	prefix := `"` + id + "."
+	var versions []int64
+	for _, tag := range strings.Split(raw, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" {
+			return 0
+		}
+		if !strings.HasPrefix(tag, prefix) || !strings.HasSuffix(tag, `"`) {
+			continue
+		}
+		if version, err := strconv.ParseInt(tag[len(prefix):len(tag)-1], 10, 64); err == nil && version > 0 {
+			versions = append(versions, version)
+		}
+	}
+	switch len(versions) {
+	case 0:
+		return -1
+	case 1:
+		return versions[0]
+	}
+	revs, err := h.svc.Revisions(c.Request.Context(), id)
+	if err != nil || len(revs) == 0 || !slices.Contains(versions, revs[0].Version) {
+		return -1
+	}
+	return revs[0].Version
+}
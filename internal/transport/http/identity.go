@@ -0,0 +1,21 @@
+package httptransport
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-grpc/internal/auth"
+)
+
+// clientIdentity exposes the verified client certificate subject to the
+// service through the request context when the listener runs with mTLS.
+func clientIdentity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if subject, ok := auth.PeerSubject(c.Request.TLS); ok {
+			log.Printf("http %s %s client=%q", c.Request.Method, c.FullPath(), subject)
+			c.Request = c.Request.WithContext(auth.WithClientSubject(c.Request.Context(), subject))
+		}
+		c.Next()
+	}
+}
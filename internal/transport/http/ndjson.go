@@ -0,0 +1,111 @@
+package httptransport
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-grpc/internal/user"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	exportFlushEvery  = 256
+)
+
+type importError struct {
+	Index int64  `json:"index"`
+	Error string `json:"error"`
+}
+
+// importUsers reads one JSON user per line. Each line is bounded by the
+// request size limit rather than the body as a whole, and malformed lines
+// are rejected without aborting the import.
+func (h *handler) importUsers(c *gin.Context) {
+	importer := h.svc.NewImporter(c.Request.Context())
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	maxLine := bufio.MaxScanTokenSize
+	if h.maxRequestBytes > 0 {
+		maxLine = int(h.maxRequestBytes)
+	}
+	// The scanner accepts tokens up to the larger of maxLine and the
+	// buffer's capacity, so the initial buffer must not exceed maxLine.
+	scanner.Buffer(make([]byte, 0, min(64<<10, maxLine)), maxLine)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var payload userPayload
+		if err := json.Unmarshal(line, &payload); err != nil {
+			importer.Reject(fmt.Errorf("invalid JSON record: %w", err))
+			continue
+		}
+		attrs, err := payload.attributes()
+		if err != nil {
+			importer.Reject(err)
+			continue
+		}
+		importer.Add(attrs)
+	}
+
+	// A line that cannot be read ends the import. Records before it stay
+	// imported, so it is rejected like any other bad line and the counts are
+	// reported along with the error.
+	status, scanErr := http.StatusOK, scanner.Err()
+	if scanErr != nil {
+		status = http.StatusBadRequest
+		if errors.Is(scanErr, bufio.ErrTooLong) {
+			status = http.StatusRequestEntityTooLarge
+			scanErr = fmt.Errorf("record exceeds %d bytes", maxLine)
+		}
+		importer.Reject(scanErr)
+	}
+
+	result := importer.Finish()
+	errs := make([]importError, len(result.Errors))
+	for i, e := range result.Errors {
+		errs[i] = importError{Index: e.Index, Error: e.Err.Error()}
+	}
+	body := gin.H{
+		"accepted": result.Accepted,
+		"rejected": result.Rejected,
+		"errors":   errs,
+	}
+	if scanErr != nil {
+		body["error"] = scanErr.Error()
+	}
+	c.JSON(status, body)
+}
+
+// exportUsers streams every user as one JSON document per line, flushing
+// periodically so the response is never buffered whole.
+func (h *handler) exportUsers(c *gin.Context) {
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	written := 0
+	err := h.svc.Export(c.Request.Context(), func(u user.User) error {
+		if err := encoder.Encode(u); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// Headers are already sent; all we can do is stop the stream.
+		_ = c.Error(err)
+		return
+	}
+	c.Writer.Flush()
+}
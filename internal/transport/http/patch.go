@@ -0,0 +1,72 @@
+package httptransport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"golang-grpc/internal/service"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+// patchUser applies a JSON merge patch (RFC 7386): keys present in the body
+// replace the stored value, null clears it and absent keys are left alone.
+func (h *handler) patchUser(c *gin.Context) {
+	if ct := c.ContentType(); ct != mergePatchContentType && ct != gin.MIMEJSON {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "content type must be " + mergePatchContentType})
+		return
+	}
+	var doc map[string]json.RawMessage
+	if !h.bindJSON(c, &doc) {
+		return
+	}
+	patch, err := decodeMergePatch(doc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id := c.Param("id")
+	updated, err := h.svc.Patch(c.Request.Context(), id, patch, h.ifMatchVersion(c, id))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	writeUser(c, http.StatusOK, updated)
+}
+
+func decodeMergePatch(doc map[string]json.RawMessage) (service.Patch, error) {
+	var patch service.Patch
+	for key, raw := range doc {
+		var dst any
+		switch key {
+		case service.FieldName:
+			dst = &patch.Attrs.Name
+		case service.FieldEmail:
+			dst = &patch.Attrs.Email
+		case service.FieldPhone:
+			dst = &patch.Attrs.Phone
+		case service.FieldAddress:
+			dst = &patch.Attrs.Address
+		case service.FieldBio:
+			dst = &patch.Attrs.Bio
+		case service.FieldTags:
+			dst = &patch.Attrs.Tags
+		case service.FieldAvatar:
+			dst = &patch.Attrs.Avatar
+		case service.FieldExpiresAt:
+			dst = &patch.Attrs.ExpiresAt
+		default:
+			return service.Patch{}, fmt.Errorf("unknown field %q", key)
+		}
+		// null leaves the zero value in place, which clears the field.
+		if err := json.Unmarshal(raw, dst); err != nil {
+			return service.Patch{}, fmt.Errorf("invalid value for %q", key)
+		}
+		patch.Fields = append(patch.Fields, key)
+	}
+	return patch, nil
+}
@@ -0,0 +1,31 @@
+package httptransport
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *handler) listRevisions(c *gin.Context) {
+	revs, err := h.svc.Revisions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revisions": revs})
+}
+
+func (h *handler) getRevision(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("rev"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "revision must be an integer version"})
+		return
+	}
+	rev, err := h.svc.Revision(c.Request.Context(), c.Param("id"), version)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rev)
+}
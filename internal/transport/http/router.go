@@ -2,7 +2,12 @@ package httptransport
 
 import (
 	"errors"
+	"expvar"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -10,48 +15,170 @@ import (
 	"golang-grpc/internal/user"
 )
 
-func NewRouter(svc *service.Service) *gin.Engine {
+// Options tunes the HTTP router. The zero value applies no body limit,
+// makes clients revalidate cached users on every read and serves no admin
+// routes; /debug/vars counts as one.
+type Options struct {
+	MaxRequestBytes int64
+	CacheMaxAge     time.Duration
+	Admin           *service.Admin
+}
+
+func NewRouter(svc *service.Service, opts Options) *gin.Engine {
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	router.Use(gin.Logger(), gin.Recovery(), clientIdentity(), compression())
 
-	handler := &handler{svc: svc}
+	handler := &handler{
+		svc:             svc,
+		maxRequestBytes: opts.MaxRequestBytes,
+		cacheControl:    cacheControl(opts.CacheMaxAge),
+		admin:           opts.Admin,
+	}
 
 	router.GET("/healthz", handler.health)
 	router.POST("/users", handler.createUser)
 	router.GET("/users", handler.listUsers)
+	router.POST("/users:action", handler.collectionAction)
+	router.GET("/users:action", handler.collectionQuery)
 	router.GET("/users/:id", handler.getUser)
+	router.GET("/users/by-email/:email", handler.getUserByEmail)
 	router.PUT("/users/:id", handler.updateUser)
+	router.PATCH("/users/:id", handler.patchUser)
 	router.DELETE("/users/:id", handler.deleteUser)
+	router.POST("/users/:id", handler.userAction)
+	router.PUT("/users/:id/avatar", handler.putAvatar)
+	router.GET("/users/:id/avatar", handler.getAvatar)
+	router.HEAD("/users/:id/avatar", handler.getAvatar)
+	router.GET("/users/:id/revisions", handler.listRevisions)
+	router.GET("/users/:id/revisions/:rev", handler.getRevision)
+
+	if opts.Admin != nil {
+		router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+		router.POST("/admin/snapshots:action", handler.snapshotAction)
+		router.POST("/admin/store:action", handler.storeAction)
+	}
 
 	return router
 }
 
 type handler struct {
-	svc *service.Service
+	svc             *service.Service
+	maxRequestBytes int64
+	cacheControl    string
+	admin           *service.Admin
 }
 
 func (h *handler) health(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// userPayload is the body of a create or update: the user's attributes and,
+// instead of an absolute expires_at, optionally a TTL in seconds.
+type userPayload struct {
+	user.Attributes
+	TTLSeconds int64 `json:"ttl_seconds"`
+}
+
+func (p userPayload) attributes() (user.Attributes, error) {
+	attrs := p.Attributes
+	var err error
+	attrs.ExpiresAt, err = service.ResolveExpiry(attrs.ExpiresAt, p.TTLSeconds)
+	return attrs, err
+}
+
 func (h *handler) createUser(c *gin.Context) {
-	var payload user.Attributes
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+	var payload userPayload
+	if !h.bindJSON(c, &payload) {
+		return
+	}
+	attrs, err := payload.attributes()
+	if err != nil {
+		handleError(c, err)
 		return
 	}
 
-	created, err := h.svc.Create(c.Request.Context(), payload)
+	created, replayed, err := h.svc.CreateIdempotent(c.Request.Context(), c.GetHeader("Idempotency-Key"), attrs)
 	if err != nil {
 		handleError(c, err)
 		return
 	}
-	c.JSON(http.StatusCreated, created)
+	if replayed {
+		c.Header("Idempotent-Replayed", "true")
+	}
+	writeUser(c, http.StatusCreated, created)
 }
 
 func (h *handler) listUsers(c *gin.Context) {
-	users := h.svc.List(c.Request.Context())
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	opts := service.ListOptions{
+		PageToken:    c.Query("page_token"),
+		IncludeTotal: c.Query("include_total") == "true",
+		Filter: user.Filter{
+			TagsAny:     queryList(c, "tags_any"),
+			TagsAll:     queryList(c, "tags_all"),
+			Email:       c.Query("email"),
+			NamePrefix:  c.Query("name_prefix"),
+			BioQuery:    c.Query("bio_query"),
+			ShowDeleted: c.Query("show_deleted") == "true",
+		},
+	}
+	if raw := c.Query("page_size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page_size must be an integer"})
+			return
+		}
+		opts.PageSize = size
+	}
+
+	page, err := h.svc.List(c.Request.Context(), opts)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	resp := gin.H{"users": page.Users}
+	if page.NextPageToken != "" {
+		resp["next_page_token"] = page.NextPageToken
+	}
+	if opts.IncludeTotal {
+		resp["total_size"] = page.TotalSize
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// collectionAction dispatches custom methods on the collection, such as
+// POST /users:batchCreate. gin captures everything after "/users" in the
+// action parameter, including the leading colon.
+func (h *handler) collectionAction(c *gin.Context) {
+	switch c.Param("action") {
+	case ":batchCreate":
+		h.batchCreate(c)
+	case ":batchGet":
+		h.batchGet(c)
+	case ":batchDelete":
+		h.batchDelete(c)
+	case ":import":
+		h.importUsers(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown collection method"})
+	}
+}
+
+func (h *handler) collectionQuery(c *gin.Context) {
+	switch c.Param("action") {
+	case ":export":
+		h.exportUsers(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown collection method"})
+	}
+}
+
+// queryList accepts both repeated parameters and comma-separated values.
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		values = append(values, strings.Split(raw, ",")...)
+	}
+	return values
 }
 
 func (h *handler) getUser(c *gin.Context) {
@@ -61,40 +188,117 @@ func (h *handler) getUser(c *gin.Context) {
 		handleError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, u)
+	h.readUser(c, u)
+}
+
+func (h *handler) getUserByEmail(c *gin.Context) {
+	u, err := h.svc.GetByEmail(c.Request.Context(), c.Param("email"))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	h.readUser(c, u)
 }
 
 func (h *handler) updateUser(c *gin.Context) {
 	id := c.Param("id")
-	var payload user.Attributes
-	if err := c.ShouldBindJSON(&payload); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+	var payload userPayload
+	if !h.bindJSON(c, &payload) {
 		return
 	}
-	updated, err := h.svc.Update(c.Request.Context(), id, payload)
+	attrs, err := payload.attributes()
 	if err != nil {
 		handleError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, updated)
+	updated, err := h.svc.Update(c.Request.Context(), id, attrs, h.ifMatchVersion(c, id))
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	writeUser(c, http.StatusOK, updated)
 }
 
 func (h *handler) deleteUser(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.svc.Delete(c.Request.Context(), id); err != nil {
+	if err := h.svc.Delete(c.Request.Context(), id, h.ifMatchVersion(c, id)); err != nil {
 		handleError(c, err)
 		return
 	}
 	c.Status(http.StatusNoContent)
 }
 
+// userAction dispatches custom methods on one user, such as
+// POST /users/42:undelete. gin captures the method in the id parameter.
+func (h *handler) userAction(c *gin.Context) {
+	id, action, _ := strings.Cut(c.Param("id"), ":")
+	switch action {
+	case "undelete":
+		restored, err := h.svc.Undelete(c.Request.Context(), id, h.ifMatchVersion(c, id))
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+		writeUser(c, http.StatusOK, restored)
+	case "purge":
+		if err := h.svc.Purge(c.Request.Context(), id, h.ifMatchVersion(c, id)); err != nil {
+			handleError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown user method"})
+	}
+}
+
+// limitBody caps the (decompressed) request body so oversized payloads are
+// rejected while reading instead of after buffering them whole. Streaming
+// endpoints such as imports bound each record instead.
+func (h *handler) limitBody(c *gin.Context) {
+	if h.maxRequestBytes > 0 && c.Request.Body != nil {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxRequestBytes)
+	}
+}
+
+func (h *handler) bindJSON(c *gin.Context, payload any) bool {
+	h.limitBody(c)
+	err := c.ShouldBindJSON(payload)
+	if err == nil {
+		return true
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit)})
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON payload"})
+	return false
+}
+
 func handleError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit)})
+		return
+	}
+	c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+}
+
+func errorStatus(err error) int {
 	switch {
 	case errors.Is(err, service.ErrInvalidInput):
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-	case errors.Is(err, user.ErrNotFound):
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, user.ErrNotFound), errors.Is(err, service.ErrSnapshotNotFound), errors.Is(err, service.ErrRevisionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, user.ErrEmailTaken), errors.Is(err, user.ErrNotDeleted):
+		return http.StatusConflict
+	case errors.Is(err, user.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, user.ErrStoreFull):
+		return http.StatusInsufficientStorage
 	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return http.StatusInternalServerError
 	}
 }
@@ -0,0 +1,601 @@
+package user
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	usersBucket = []byte("users")
+	// revisionsBucket holds the earlier versions of users, keyed by ID and
+	// version, so a user's versions are adjacent and in order.
+	revisionsBucket = []byte("revisions")
+)
+
+// BoltStore is a Repository backed by an embedded bbolt database, so every
+// read decodes a record from the memory-mapped file and every write commits
+// (and by default fsyncs) a transaction. Soft-deleted users stay in the
+// bucket with DeletedAt set. The secondary indexes and the ID order are
+// kept in memory and rebuilt from the database on open.
+type BoltStore struct {
+	db *bolt.DB
+
+	// mu guards ids and serializes writers, so the indexes always match the
+	// last committed transaction.
+	mu  sync.RWMutex
+	ids *catalog
+
+	revisions int
+	reaper    *reaper
+}
+
+var _ Repository = (*BoltStore)(nil)
+
+// OpenBolt opens or creates the database at path, with the reaper running
+// if opts.ReapInterval is set.
+func OpenBolt(path string, opts Options) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	s := &BoltStore{db: db, revisions: max(opts.Revisions, 0)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(usersBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(revisionsBucket); err != nil {
+			return err
+		}
+		return s.loadLocked(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("load %s: %w", path, err)
+	}
+	s.reaper = newReaper(s.reap)
+	s.reaper.start(opts.ReapInterval)
+	return s, nil
+}
+
+func (s *BoltStore) Create(attrs Attributes) (User, error) {
+	items := s.CreateBatch([]Attributes{attrs})
+	return items[0].User, items[0].Err
+}
+
+// CreateBatch creates all users in one transaction. Items are applied in
+// order, so a later item cannot reuse an email taken earlier in the batch.
+// If the transaction fails, every item reports the failure.
+func (s *BoltStore) CreateBatch(attrs []Attributes) []BatchItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]BatchItem, len(attrs))
+	var created []User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		for i, a := range attrs {
+			if _, taken := s.ids.emailOwner(a.Email); taken {
+				items[i].Err = ErrEmailTaken
+				continue
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			u := newUser(int64(seq), a)
+			if err := putUser(b, u); err != nil {
+				return err
+			}
+			// Index right away so later items see the email as taken.
+			s.ids.add(u)
+			created = append(created, u)
+			items[i].User = u
+		}
+		return nil
+	})
+	if err != nil {
+		for _, u := range created {
+			s.ids.remove(u)
+		}
+		for i := range items {
+			items[i] = BatchItem{Err: err}
+		}
+	}
+	return items
+}
+
+func (s *BoltStore) Get(id string) (User, error) {
+	items := s.GetBatch([]string{id})
+	return items[0].User, items[0].Err
+}
+
+func (s *BoltStore) GetByEmail(email string) (User, error) {
+	s.mu.RLock()
+	n, ok := s.ids.emailOwner(email)
+	s.mu.RUnlock()
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return s.Get(strconv.FormatInt(n, 10))
+}
+
+func (s *BoltStore) GetBatch(ids []string) []BatchItem {
+	items := make([]BatchItem, len(ids))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		for i, id := range ids {
+			items[i].User, items[i].Err = getUserAs(b, id, false)
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range items {
+			items[i] = BatchItem{Err: err}
+		}
+	}
+	return items
+}
+
+func (s *BoltStore) Update(id string, attrs Attributes, expectedVersion int64) (User, error) {
+	return s.Modify(id, expectedVersion, func(Attributes) (Attributes, error) { return attrs, nil })
+}
+
+func (s *BoltStore) Modify(id string, expectedVersion int64, fn func(Attributes) (Attributes, error)) (User, error) {
+	return s.mutate(id, expectedVersion, false, func(old User) (User, error) {
+		attrs, err := fn(old.Attributes)
+		if err != nil {
+			return User{}, err
+		}
+		if owner, taken := s.ids.emailOwner(attrs.Email); taken && owner != numericID(id) {
+			return User{}, ErrEmailTaken
+		}
+		return revise(old, attrs), nil
+	})
+}
+
+func (s *BoltStore) SetAvatar(id string, avatar []byte, expectedVersion int64) (User, error) {
+	return s.mutate(id, expectedVersion, false, func(old User) (User, error) {
+		u := old
+		u.Avatar = avatar
+		u.Version++
+		u.UpdatedAt = time.Now().UTC()
+		return u, nil
+	})
+}
+
+// mutate replaces user id, which must be soft-deleted exactly when deleted
+// is set, with the result of fn in one transaction and then updates the
+// indexes. fn runs with s.mu held.
+func (s *BoltStore) mutate(id string, expectedVersion int64, deleted bool, fn func(User) (User, error)) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var old, u User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		var err error
+		if old, err = getUserAs(b, id, deleted); err != nil {
+			return err
+		}
+		if err := checkVersion(old, expectedVersion); err != nil {
+			return err
+		}
+		if u, err = fn(old); err != nil {
+			return err
+		}
+		if err := putUser(b, u); err != nil {
+			return err
+		}
+		return archiveUser(tx, old, u, s.revisions)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	s.ids.replace(old, u)
+	return u, nil
+}
+
+func (s *BoltStore) Delete(id string, expectedVersion int64) error {
+	_, err := s.mutate(id, expectedVersion, false, func(old User) (User, error) { return softDelete(old), nil })
+	return err
+}
+
+// DeleteBatch soft-deletes the users in one transaction.
+func (s *BoltStore) DeleteBatch(ids []string) []BatchItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]BatchItem, len(ids))
+	olds := make([]User, len(ids))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		for i, id := range ids {
+			old, err := getUserAs(b, id, false)
+			if err != nil {
+				items[i].Err = err
+				continue
+			}
+			u := softDelete(old)
+			if err := putUser(b, u); err != nil {
+				return err
+			}
+			if err := archiveUser(tx, old, u, s.revisions); err != nil {
+				return err
+			}
+			olds[i], items[i].User = old, u
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range items {
+			items[i] = BatchItem{Err: err}
+		}
+		return items
+	}
+	for i, item := range items {
+		if item.Err == nil {
+			s.ids.replace(olds[i], item.User)
+		}
+	}
+	return items
+}
+
+func (s *BoltStore) Undelete(id string, expectedVersion int64) (User, error) {
+	return s.mutate(id, expectedVersion, true, func(old User) (User, error) {
+		if _, taken := s.ids.emailOwner(old.Email); taken {
+			return User{}, ErrEmailTaken
+		}
+		return undelete(old), nil
+	})
+}
+
+func (s *BoltStore) Purge(id string, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var old User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		var err error
+		if old, err = getUserAs(b, id, true); err != nil {
+			return err
+		}
+		if err := checkVersion(old, expectedVersion); err != nil {
+			return err
+		}
+		if err := b.Delete(userKey(numericID(id))); err != nil {
+			return err
+		}
+		return dropRevisions(tx, numericID(id))
+	})
+	if err != nil {
+		return err
+	}
+	s.ids.remove(old)
+	return nil
+}
+
+func (s *BoltStore) Revisions(id string) ([]Revision, error) {
+	var revs []Revision
+	err := s.db.View(func(tx *bolt.Tx) error {
+		u, err := getUser(tx.Bucket(usersBucket), id)
+		if err != nil {
+			return err
+		}
+		if u.Expired(time.Now()) {
+			return ErrNotFound
+		}
+		var earlier []User
+		c := tx.Bucket(revisionsBucket).Cursor()
+		prefix := userKey(numericID(id))
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			old, err := decodeUser(v)
+			if err != nil {
+				return err
+			}
+			earlier = append(earlier, old)
+		}
+		revs = revisions(u, earlier, s.revisions)
+		return nil
+	})
+	return revs, err
+}
+
+// List returns every user in ID order, soft-deleted ones included. Keys are
+// big-endian IDs, so a cursor walks them in order.
+func (s *BoltStore) List() ([]User, error) {
+	var users []User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(_, v []byte) error {
+			u, err := decodeUser(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, u)
+			return nil
+		})
+	})
+	return users, err
+}
+
+// ListAfter selects IDs from the in-memory order and indexes, then reads
+// only the users on the page. Both happen under the read lock, so no write
+// can commit in between and every selected user is read as selected.
+func (s *BoltStore) ListAfter(after int64, limit int, filter Filter) (users []User, next int64, total int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	page, more, total := s.ids.page(filter, time.Now(), after, limit)
+	users = make([]User, 0, len(page))
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		for _, n := range page {
+			v := b.Get(userKey(n))
+			if v == nil {
+				return fmt.Errorf("user %d is indexed but not stored", n)
+			}
+			u, err := decodeUser(v)
+			if err != nil {
+				return err
+			}
+			users = append(users, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return users, nextAfter(page, more), total, nil
+}
+
+// Restore writes all users in one transaction. The indexes are rebuilt from
+// the database afterwards, whether or not the transaction committed.
+func (s *BoltStore) Restore(users []User, replace bool) []BatchItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]BatchItem, len(users))
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		seq := b.Sequence()
+		ids := s.ids
+		if replace {
+			var err error
+			if b, err = clearBucket(tx); err != nil {
+				return err
+			}
+			ids = newCatalog()
+		}
+		for i, u := range users {
+			if !validID(u.ID) {
+				items[i].Err = ErrInvalidID
+				continue
+			}
+			n := numericID(u.ID)
+			if owner, taken := ids.emailOwner(u.Email); taken && owner != n && !u.Deleted() {
+				items[i].Err = ErrEmailTaken
+				continue
+			}
+			old, err := getUser(b, u.ID)
+			if err := putUser(b, u); err != nil {
+				return err
+			}
+			if err == nil {
+				ids.replace(old, u)
+				if err := archiveUser(tx, old, u, s.revisions); err != nil {
+					return err
+				}
+			} else {
+				ids.add(u)
+			}
+			seq = max(seq, uint64(n))
+			items[i].User = u
+		}
+		return b.SetSequence(seq)
+	})
+	if reloadErr := s.db.View(s.loadLocked); err == nil {
+		err = reloadErr
+	}
+	if err != nil {
+		for i := range items {
+			items[i] = BatchItem{Err: err}
+		}
+	}
+	return items
+}
+
+func (s *BoltStore) Reset() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.ids.len()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		_, err := clearBucket(tx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.ids = newCatalog()
+	return n, nil
+}
+
+// Reap removes the expired users and their revisions in one transaction.
+func (s *BoltStore) Reap() (int, error) {
+	return s.reaper.run()
+}
+
+func (s *BoltStore) ReapStats() ReapStats {
+	return s.reaper.stats()
+}
+
+func (s *BoltStore) reap(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reaped []User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(usersBucket)
+		for _, n := range s.ids.expired(now) {
+			u, err := getUser(b, strconv.FormatInt(n, 10))
+			if err != nil {
+				return err
+			}
+			if err := b.Delete(userKey(n)); err != nil {
+				return err
+			}
+			if err := dropRevisions(tx, n); err != nil {
+				return err
+			}
+			reaped = append(reaped, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, u := range reaped {
+		s.ids.remove(u)
+	}
+	return len(reaped), nil
+}
+
+// Close stops the reaper and closes the database.
+func (s *BoltStore) Close() error {
+	s.reaper.close()
+	return s.db.Close()
+}
+
+// loadLocked rebuilds the ID order and indexes from the database.
+func (s *BoltStore) loadLocked(tx *bolt.Tx) error {
+	s.ids = newCatalog()
+	return tx.Bucket(usersBucket).ForEach(func(_, v []byte) error {
+		u, err := decodeUser(v)
+		if err != nil {
+			return err
+		}
+		s.ids.add(u)
+		return nil
+	})
+}
+
+// clearBucket replaces the users bucket with an empty one that keeps the
+// ID sequence, and drops every revision.
+func clearBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	seq := tx.Bucket(usersBucket).Sequence()
+	for _, name := range [][]byte{usersBucket, revisionsBucket} {
+		if err := tx.DeleteBucket(name); err != nil {
+			return nil, err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return nil, err
+		}
+	}
+	b := tx.Bucket(usersBucket)
+	return b, b.SetSequence(seq)
+}
+
+// archiveUser stores old, which u replaces, as an earlier version and
+// discards all but the newest keep of them. As with archive, a u that is
+// not newer than old drops the user's revisions instead.
+func archiveUser(tx *bolt.Tx, old, u User, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	n := numericID(u.ID)
+	if u.Version <= old.Version {
+		return dropRevisions(tx, n)
+	}
+	v, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	b := tx.Bucket(revisionsBucket)
+	if err := b.Put(revisionKey(n, old.Version), v); err != nil {
+		return err
+	}
+	keys := revisionKeys(b, n)
+	for _, k := range keys[:max(len(keys)-keep, 0)] {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropRevisions(tx *bolt.Tx, n int64) error {
+	b := tx.Bucket(revisionsBucket)
+	for _, k := range revisionKeys(b, n) {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revisionKeys returns copies of the keys of user n's revisions, oldest
+// first, so the caller may delete them.
+func revisionKeys(b *bolt.Bucket, n int64) [][]byte {
+	var keys [][]byte
+	prefix := userKey(n)
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, bytes.Clone(k))
+	}
+	return keys
+}
+
+func revisionKey(n, version int64) []byte {
+	return binary.BigEndian.AppendUint64(userKey(n), uint64(version))
+}
+
+func userKey(n int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(n))
+	return key
+}
+
+// getUserAs reads user id, which must be soft-deleted exactly when deleted
+// is set.
+func getUserAs(b *bolt.Bucket, id string, deleted bool) (User, error) {
+	u, err := getUser(b, id)
+	if err != nil {
+		return User{}, err
+	}
+	return u, checkState(u, deleted)
+}
+
+func getUser(b *bolt.Bucket, id string) (User, error) {
+	if !validID(id) {
+		return User{}, ErrNotFound
+	}
+	v := b.Get(userKey(numericID(id)))
+	if v == nil {
+		return User{}, ErrNotFound
+	}
+	return decodeUser(v)
+}
+
+func putUser(b *bolt.Bucket, u User) error {
+	v, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return b.Put(userKey(numericID(u.ID)), v)
+}
+
+// decodeUser copies out of v, which is only valid during the transaction.
+func decodeUser(v []byte) (User, error) {
+	var u User
+	if err := json.Unmarshal(v, &u); err != nil {
+		return User{}, fmt.Errorf("corrupt user record: %w", err)
+	}
+	return u, nil
+}
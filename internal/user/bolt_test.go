@@ -0,0 +1,109 @@
+package user
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func openTestBolt(t *testing.T, path string) *BoltStore {
+	t.Helper()
+	s, err := OpenBolt(path, Options{Revisions: 3})
+	if err != nil {
+		t.Fatalf("OpenBolt: %v", err)
+	}
+	return s
+}
+
+func TestBoltReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	s := openTestBolt(t, path)
+	createUsers(t, s, 3)
+	if _, err := s.Update("1", Attributes{Name: "Renamed", Email: "renamed@example.com", Tags: []string{"kept"}}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestBolt(t, path)
+	defer s.Close()
+	u, err := s.GetByEmail("RENAMED@example.com")
+	if err != nil || u.ID != "1" || u.Version != 2 {
+		t.Fatalf("GetByEmail = %+v, %v; want version 2 of user 1", u, err)
+	}
+	if _, err := s.Get("2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted user: err = %v, want ErrNotFound", err)
+	}
+	if revs, err := s.Revisions("1"); err != nil || len(revs) != 2 {
+		t.Fatalf("Revisions = %d, %v; want 2", len(revs), err)
+	}
+	pages, _ := listAll(t, s, 10, Filter{TagsAny: []string{"kept"}})
+	if got := flatten(pages); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Fatalf("tag filter after reopen = %v, want [1]", got)
+	}
+	pages, _ = listAll(t, s, 10, Filter{ShowDeleted: true})
+	if got := flatten(pages); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Fatalf("list after reopen = %v", got)
+	}
+	created, err := s.Create(Attributes{Name: "Next", Email: "next@example.com"})
+	if err != nil || created.ID != "4" {
+		t.Fatalf("Create after reopen = %q, %v; want ID 4", created.ID, err)
+	}
+}
+
+// TestBoltListAfterDuringPurges lists one user at a time while other users
+// are deleted and purged, which must never yield a short page or a total
+// that disagrees with it.
+func TestBoltListAfterDuringPurges(t *testing.T) {
+	s := openTestBolt(t, filepath.Join(t.TempDir(), "users.db"))
+	defer s.Close()
+	ids := createUsers(t, s, 200)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, id := range ids[1:] {
+			if err := s.Delete(id, 0); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := s.Purge(id, 0); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for round := 0; round < 20; round++ {
+		var after int64
+		for {
+			users, next, total, err := s.ListAfter(after, 1, Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != 0 && len(users) == 0 {
+				t.Fatalf("empty page after %d claims more follow", after)
+			}
+			if total < len(users) {
+				t.Fatalf("total %d is below the %d users on the page", total, len(users))
+			}
+			if next == 0 {
+				break
+			}
+			after = next
+		}
+	}
+	wg.Wait()
+
+	users, next, total, err := s.ListAfter(0, 10, Filter{ShowDeleted: true})
+	if err != nil || next != 0 || total != 1 || len(users) != 1 {
+		t.Fatalf("after purging: %d users, next %d, total %d, %v", len(users), next, total, err)
+	}
+}
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// Eviction policies for a Store at capacity.
+const (
+	// EvictReject fails creates and growing updates with ErrStoreFull.
+	EvictReject = "reject"
+	// EvictLRU makes room by purging the least recently used users,
+	// soft-deleted ones before live ones. Reading or writing a user counts
+	// as a use; listing it does not.
+	EvictLRU = "lru"
+)
+
+// Capacity bounds a Store. MaxUsers counts users, soft-deleted ones
+// included, and MaxBytes bounds the estimated memory of the users and their
+// kept revisions; zero means no limit. Deletes, undeletes and purges are
+// never refused, so a delete's new revision may overshoot MaxBytes until
+// the next write makes room.
+type Capacity struct {
+	MaxUsers int
+	MaxBytes int64
+	Eviction string
+}
+
+func (c Capacity) bounded() bool {
+	return c.MaxUsers > 0 || c.MaxBytes > 0
+}
+
+// CapacityStats reports how much of its capacity a Store uses, how many
+// users were evicted to make room and how many writes were rejected.
+type CapacityStats struct {
+	Users    int    `json:"users"`
+	Bytes    int64  `json:"bytes"`
+	MaxUsers int    `json:"max_users"`
+	MaxBytes int64  `json:"max_bytes"`
+	Eviction string `json:"eviction"`
+	Evicted  uint64 `json:"evicted"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// userOverhead approximates the memory a stored user takes beyond its
+// strings and slices: the struct, its map entries and its index entries.
+const userOverhead = 512
+
+// userSize estimates the memory of one version of u.
+func userSize(u User) int64 {
+	n := userOverhead + len(u.ID) + len(u.Name) + len(u.Email) + len(u.Phone) + len(u.Address) + len(u.Bio) + len(u.Avatar)
+	for _, tag := range u.Tags {
+		n += 16 + len(tag)
+	}
+	return int64(n)
+}
+
+// usage tracks the estimated size of every user of a bounded Store, in
+// order of last use. Sizes only change under the store's write lock, but
+// reads reorder users under the read lock, so the list has a lock of its
+// own.
+type usage struct {
+	limits Capacity
+
+	mu      sync.Mutex
+	bytes   int64
+	recent  *list.List // of *usageEntry, most recently used first
+	entries map[string]*list.Element
+
+	evicted  atomic.Uint64
+	rejected atomic.Uint64
+}
+
+type usageEntry struct {
+	id      string
+	bytes   int64
+	deleted bool
+}
+
+func newUsage(limits Capacity) *usage {
+	return &usage{limits: limits, recent: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// set records the size and deletion state of user id and marks it as just
+// used.
+func (u *usage) set(id string, bytes int64, deleted bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if e, ok := u.entries[id]; ok {
+		entry := e.Value.(*usageEntry)
+		u.bytes += bytes - entry.bytes
+		entry.bytes, entry.deleted = bytes, deleted
+		u.recent.MoveToFront(e)
+		return
+	}
+	u.entries[id] = u.recent.PushFront(&usageEntry{id: id, bytes: bytes, deleted: deleted})
+	u.bytes += bytes
+}
+
+func (u *usage) drop(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if e, ok := u.entries[id]; ok {
+		u.bytes -= e.Value.(*usageEntry).bytes
+		u.recent.Remove(e)
+		delete(u.entries, id)
+	}
+}
+
+// touch marks user id as just used. Only LRU eviction cares, so other
+// policies skip the lock.
+func (u *usage) touch(id string) {
+	if u.limits.Eviction != EvictLRU {
+		return
+	}
+	u.mu.Lock()
+	if e, ok := u.entries[id]; ok {
+		u.recent.MoveToFront(e)
+	}
+	u.mu.Unlock()
+}
+
+func (u *usage) clear() {
+	u.mu.Lock()
+	u.bytes = 0
+	u.recent.Init()
+	u.entries = make(map[string]*list.Element)
+	u.mu.Unlock()
+}
+
+// plan returns the users to evict, never keep, so that a store holding the
+// given number of users and growing by grow bytes fits the limits. It
+// reports false if the write cannot fit: because the policy does not
+// evict, because the write alone exceeds a limit, or because evicting
+// everyone but keep would not be enough.
+func (u *usage) plan(keep string, users int, grow int64) ([]string, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	needUsers := 0
+	if u.limits.MaxUsers > 0 {
+		needUsers = users - u.limits.MaxUsers
+	}
+	var needBytes int64
+	if u.limits.MaxBytes > 0 && grow > 0 {
+		needBytes = u.bytes + grow - u.limits.MaxBytes
+	}
+	if needUsers <= 0 && needBytes <= 0 {
+		return nil, true
+	}
+	if u.limits.Eviction != EvictLRU || u.limits.MaxBytes > 0 && grow > u.limits.MaxBytes {
+		return nil, false
+	}
+
+	// Soft-deleted users go first, so live ones are only purged once no
+	// deleted user is left to make room; each group goes least recently
+	// used first.
+	var victims []string
+	for _, deleted := range []bool{true, false} {
+		for e := u.recent.Back(); e != nil && (needUsers > 0 || needBytes > 0); e = e.Prev() {
+			entry := e.Value.(*usageEntry)
+			if entry.deleted != deleted || entry.id == keep {
+				continue
+			}
+			victims = append(victims, entry.id)
+			needUsers--
+			needBytes -= entry.bytes
+		}
+	}
+	if needUsers > 0 || needBytes > 0 {
+		return nil, false
+	}
+	return victims, true
+}
+
+func (u *usage) stats(users int) CapacityStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return CapacityStats{
+		Users:    users,
+		Bytes:    u.bytes,
+		MaxUsers: u.limits.MaxUsers,
+		MaxBytes: u.limits.MaxBytes,
+		Eviction: u.limits.Eviction,
+		Evicted:  u.evicted.Load(),
+		Rejected: u.rejected.Load(),
+	}
+}
@@ -0,0 +1,169 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storedIDs returns the IDs of every user in s, soft-deleted ones included.
+func storedIDs(t *testing.T, s *Store) []string {
+	t.Helper()
+	users, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+func TestEvictLRUOrder(t *testing.T) {
+	s := NewStore(Options{Capacity: Capacity{MaxUsers: 3, Eviction: EvictLRU}})
+	defer s.Close()
+	createUsers(t, s, 3)
+
+	// Reading user 1 makes user 2 the least recently used.
+	if _, err := s.Get("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(Attributes{Name: "Dan", Email: "dan@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := storedIDs(t, s), []string{"1", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after the first eviction: %v, want %v", got, want)
+	}
+
+	// A soft-deleted user goes before any live one, however recently used.
+	if err := s.Delete("4", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(Attributes{Name: "Eve", Email: "eve@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := storedIDs(t, s), []string{"1", "3", "5"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after evicting a deleted user: %v, want %v", got, want)
+	}
+	if st := s.CapacityStats(); st.Users != 3 || st.Evicted != 2 || st.Rejected != 0 {
+		t.Fatalf("CapacityStats = %+v", st)
+	}
+}
+
+func TestEvictLRUKeepsTheWrittenUser(t *testing.T) {
+	s := NewStore(Options{Capacity: Capacity{MaxBytes: 3 * userOverhead, Eviction: EvictLRU}})
+	defer s.Close()
+	createUsers(t, s, 2)
+	if _, err := s.Get("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Growing user 2, the least recently used, must evict user 1 instead.
+	grown := Attributes{Name: "User 1", Email: "user-1@example.com", Bio: strings.Repeat("b", userOverhead)}
+	if _, err := s.Update("2", grown, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := storedIDs(t, s); !reflect.DeepEqual(got, []string{"2"}) {
+		t.Fatalf("after growing user 2: %v, want [2]", got)
+	}
+
+	// With nobody else left to evict, the next growth cannot fit.
+	grown.Bio = strings.Repeat("b", 2*userOverhead)
+	if _, err := s.Update("2", grown, 0); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("growing the only user past the limit: err = %v, want ErrStoreFull", err)
+	}
+	if u, err := s.Get("2"); err != nil || u.Version != 2 {
+		t.Fatalf("Get = version %d, %v; want the rejected update to leave version 2", u.Version, err)
+	}
+}
+
+func TestEvictLRUByteLimit(t *testing.T) {
+	size := userSize(newUser(1, Attributes{Name: "User 0", Email: "user-0@example.com"}))
+	s := NewStore(Options{Capacity: Capacity{MaxBytes: 3*size + size/2, Eviction: EvictLRU}})
+	defer s.Close()
+	createUsers(t, s, 3)
+
+	// A user twice the usual size needs two others evicted.
+	big := Attributes{Name: "Big", Email: "big@example.com", Bio: strings.Repeat("b", int(size))}
+	if _, err := s.Create(big); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := storedIDs(t, s), []string{"3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("after the big create: %v, want %v", got, want)
+	}
+	st := s.CapacityStats()
+	if st.Bytes > st.MaxBytes || st.Evicted != 2 {
+		t.Fatalf("CapacityStats = %+v", st)
+	}
+
+	// A user larger than the whole limit is refused without evicting anyone.
+	big.Email = "huge@example.com"
+	big.Bio = strings.Repeat("b", int(st.MaxBytes))
+	if _, err := s.Create(big); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("create larger than the limit: err = %v, want ErrStoreFull", err)
+	}
+	if got := s.CapacityStats(); got.Evicted != 2 || got.Rejected != 1 {
+		t.Fatalf("CapacityStats after the refused create = %+v", got)
+	}
+}
+
+func TestEvictRejectRefusesWrites(t *testing.T) {
+	s := NewStore(Options{Capacity: Capacity{MaxUsers: 2, Eviction: EvictReject}})
+	defer s.Close()
+	createUsers(t, s, 2)
+
+	if _, err := s.Create(Attributes{Name: "Carl", Email: "carl@example.com"}); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("create at capacity: err = %v, want ErrStoreFull", err)
+	}
+	// Deletes are never refused, but the deleted user still counts.
+	if err := s.Delete("1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(Attributes{Name: "Carl", Email: "carl@example.com"}); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("create after a soft delete: err = %v, want ErrStoreFull", err)
+	}
+	if err := s.Purge("1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(Attributes{Name: "Carl", Email: "carl@example.com"}); err != nil {
+		t.Fatalf("create after a purge: %v", err)
+	}
+	if st := s.CapacityStats(); st.Users != 2 || st.Evicted != 0 || st.Rejected != 2 {
+		t.Fatalf("CapacityStats = %+v", st)
+	}
+}
+
+// TestEvictionWaitsForTheWAL checks that a write whose log append fails
+// evicts nobody.
+func TestEvictionWaitsForTheWAL(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenDurable(dir, Options{Capacity: Capacity{MaxUsers: 2, Eviction: EvictLRU}}, DurabilityOptions{Fsync: FsyncAlways})
+	if err != nil {
+		t.Fatalf("OpenDurable: %v", err)
+	}
+	createUsers(t, s, 2)
+
+	// JSON cannot encode a year past 9999, so only the put fails to log.
+	never := time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := s.Create(Attributes{Name: "Carl", Email: "carl@example.com", ExpiresAt: &never}); err == nil {
+		t.Fatal("create with an unloggable user succeeded")
+	}
+	if got := storedIDs(t, s); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("after the failed create: %v, want [1 2]", got)
+	}
+	if st := s.CapacityStats(); st.Evicted != 0 {
+		t.Fatalf("CapacityStats = %+v, want no evictions", st)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestWAL(t, dir)
+	defer s.Close()
+	if got := storedIDs(t, s); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("after replay: %v, want [1 2]", got)
+	}
+}
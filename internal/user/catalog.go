@@ -0,0 +1,258 @@
+package user
+
+import (
+	"sort"
+	"time"
+)
+
+// catalog tracks the IDs a store holds, in ascending order, together with
+// their secondary indexes. The IDs are kept in skip lists, so adding or
+// removing one, soft deletes included, costs a logarithmic seek.
+// Soft-deleted users are kept in a set of their own, so ordinary lists,
+// filters and email lookups never see them. A catalog is guarded by the
+// lock of the store or shard that owns it.
+//
+// Users with an expiry are also kept in order of it, so the expired ones
+// are always a prefix of expiring and finding them is a binary search.
+// expiresAt holds the same expiries by ID, so lists can skip expired users
+// as they come across them.
+type catalog struct {
+	live         *skipList[int64]
+	liveIndex    *index
+	deleted      *skipList[int64]
+	deletedIndex *index
+	expiring     []expiry
+	expiresAt    map[int64]int64
+}
+
+// expiry is an entry of the expiry order: the user's ExpiresAt in Unix
+// nanoseconds and its ID.
+type expiry struct {
+	at int64
+	id int64
+}
+
+func (e expiry) before(o expiry) bool {
+	return e.at < o.at || e.at == o.at && e.id < o.id
+}
+
+func newCatalog() *catalog {
+	return &catalog{
+		live:         newSkipList(lessID),
+		liveIndex:    newIndex(),
+		deleted:      newSkipList(lessID),
+		deletedIndex: newIndex(),
+		expiresAt:    make(map[int64]int64),
+	}
+}
+
+func (c *catalog) add(u User) {
+	n := numericID(u.ID)
+	c.track(u)
+	if u.Deleted() {
+		c.deleted.insert(n)
+		c.deletedIndex.add(n, u.Attributes)
+		return
+	}
+	c.live.insert(n)
+	c.liveIndex.add(n, u.Attributes)
+}
+
+func (c *catalog) remove(u User) {
+	n := numericID(u.ID)
+	c.untrack(u)
+	if u.Deleted() {
+		c.deleted.remove(n)
+		c.deletedIndex.remove(n, u.Attributes)
+		return
+	}
+	c.live.remove(n)
+	c.liveIndex.remove(n, u.Attributes)
+}
+
+// replace swaps old for its next version u. Only a delete or undelete moves
+// the ID between the sets; other changes just update the indexes.
+func (c *catalog) replace(old, u User) {
+	if old.Deleted() != u.Deleted() {
+		c.remove(old)
+		c.add(u)
+		return
+	}
+	if !sameTime(old.ExpiresAt, u.ExpiresAt) {
+		c.untrack(old)
+		c.track(u)
+	}
+	ix := c.liveIndex
+	if u.Deleted() {
+		ix = c.deletedIndex
+	}
+	ix.update(numericID(u.ID), old.Attributes, u.Attributes)
+}
+
+// emailOwner returns the live user owning email.
+func (c *catalog) emailOwner(email string) (int64, bool) {
+	return c.liveIndex.firstByEmail(email)
+}
+
+// page returns up to limit IDs selected by f that are greater than after,
+// in ascending order, whether more follow and how many are selected in all.
+// Users expired at now are left out. Without conditions the ID lists are
+// walked from after, so a page costs its own length rather than the
+// store's; filtered pages are answered from the indexes.
+func (c *catalog) page(f Filter, now time.Time, after int64, limit int) (ids []int64, more bool, total int) {
+	at := now.UnixNano()
+	if f.IsZero() {
+		ids = c.idsAfter(c.live, after, limit, at)
+		total = c.live.len()
+		if f.ShowDeleted {
+			ids = mergeIDs(ids, c.idsAfter(c.deleted, after, limit, at))
+			total += c.deleted.len()
+		}
+		total -= c.expiredCount(at, f.ShowDeleted)
+	} else {
+		ids = c.unexpired(c.liveIndex.match(f), at)
+		if f.ShowDeleted {
+			ids = mergeIDs(ids, c.unexpired(c.deletedIndex.match(f), at))
+		}
+		total = len(ids)
+		ids = ids[sort.Search(len(ids), func(i int) bool { return ids[i] > after }):]
+	}
+	if len(ids) > limit {
+		return ids[:limit], true, total
+	}
+	return ids, false, total
+}
+
+// nextAfter returns the after of the page following ids, or zero when no
+// more IDs follow.
+func nextAfter(ids []int64, more bool) int64 {
+	if !more || len(ids) == 0 {
+		return 0
+	}
+	return ids[len(ids)-1]
+}
+
+// idsAfter returns the first IDs of l greater than after that have not
+// expired at at, in Unix nanoseconds, one more than limit if there are that
+// many.
+func (c *catalog) idsAfter(l *skipList[int64], after int64, limit int, at int64) []int64 {
+	var ids []int64
+	l.ascend(after, func(n int64) bool {
+		if n > after && !c.expiredAt(n, at) {
+			ids = append(ids, n)
+		}
+		return len(ids) <= limit
+	})
+	return ids
+}
+
+// unexpired drops the users expired at at from ids, in place.
+func (c *catalog) unexpired(ids []int64, at int64) []int64 {
+	if len(c.expiresAt) == 0 {
+		return ids
+	}
+	kept := ids[:0]
+	for _, n := range ids {
+		if !c.expiredAt(n, at) {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+func (c *catalog) expiredAt(n, at int64) bool {
+	expires, ok := c.expiresAt[n]
+	return ok && expires <= at
+}
+
+// expiredCount returns how many live users, and with deleted also how many
+// soft-deleted ones, have expired at at. Only the expired prefix of the
+// expiry order is visited.
+func (c *catalog) expiredCount(at int64, deleted bool) int {
+	end := sort.Search(len(c.expiring), func(i int) bool { return c.expiring[i].at > at })
+	if deleted {
+		return end
+	}
+	n := 0
+	for _, e := range c.expiring[:end] {
+		if c.live.contains(e.id) {
+			n++
+		}
+	}
+	return n
+}
+
+// expired returns the IDs of the users expired at now, live and deleted, in
+// ascending order.
+func (c *catalog) expired(now time.Time) []int64 {
+	at := now.UnixNano()
+	end := sort.Search(len(c.expiring), func(i int) bool { return c.expiring[i].at > at })
+	if end == 0 {
+		return nil
+	}
+	ids := make([]int64, end)
+	for i, e := range c.expiring[:end] {
+		ids[i] = e.id
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// track adds u to the expiry order if it has an expiry.
+func (c *catalog) track(u User) {
+	if u.ExpiresAt == nil {
+		return
+	}
+	e := expiry{at: u.ExpiresAt.UnixNano(), id: numericID(u.ID)}
+	i := sort.Search(len(c.expiring), func(i int) bool { return !c.expiring[i].before(e) })
+	c.expiring = append(c.expiring, expiry{})
+	copy(c.expiring[i+1:], c.expiring[i:])
+	c.expiring[i] = e
+	c.expiresAt[e.id] = e.at
+}
+
+// untrack removes u from the expiry order.
+func (c *catalog) untrack(u User) {
+	if u.ExpiresAt == nil {
+		return
+	}
+	e := expiry{at: u.ExpiresAt.UnixNano(), id: numericID(u.ID)}
+	i := sort.Search(len(c.expiring), func(i int) bool { return !c.expiring[i].before(e) })
+	if i < len(c.expiring) && c.expiring[i] == e {
+		c.expiring = append(c.expiring[:i], c.expiring[i+1:]...)
+		delete(c.expiresAt, e.id)
+	}
+}
+
+// all returns every ID, live and deleted, in ascending order.
+func (c *catalog) all() []int64 {
+	return mergeIDs(c.live.values(), c.deleted.values())
+}
+
+func (c *catalog) len() int {
+	return c.live.len() + c.deleted.len()
+}
+
+func lessID(a, b int64) bool {
+	return a < b
+}
+
+// mergeIDs merges two disjoint ascending ID lists.
+func mergeIDs(a, b []int64) []int64 {
+	if len(b) == 0 {
+		return a
+	}
+	if len(a) == 0 {
+		return b
+	}
+	merged := make([]int64, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] < b[0] {
+			merged, a = append(merged, a[0]), a[1:]
+		} else {
+			merged, b = append(merged, b[0]), b[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReapStats counts the work of a store's Reap calls, whether made by the
+// background reaper or directly. Reaped is the number of expired users
+// removed, and LastDuration is how long the last run took.
+type ReapStats struct {
+	Runs         uint64        `json:"runs"`
+	Reaped       uint64        `json:"reaped"`
+	Failures     uint64        `json:"failures"`
+	LastRun      time.Time     `json:"last_run"`
+	LastDuration time.Duration `json:"last_duration_ns"`
+}
+
+// reaper runs a store's reap function and records its work. Started with an
+// interval, it also runs it in the background until stopped.
+type reaper struct {
+	reap func(now time.Time) (int, error)
+
+	runs     atomic.Uint64
+	reaped   atomic.Uint64
+	failures atomic.Uint64
+	lastRun  atomic.Int64
+	lastTook atomic.Int64
+
+	once sync.Once
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newReaper(reap func(now time.Time) (int, error)) *reaper {
+	return &reaper{reap: reap, stop: make(chan struct{})}
+}
+
+// start runs the reaper every interval until stopped. A non-positive
+// interval leaves it to explicit runs.
+func (r *reaper) start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := r.run(); err != nil {
+					log.Printf("reaping expired users failed: %v", err)
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *reaper) run() (int, error) {
+	start := time.Now()
+	n, err := r.reap(start)
+	r.runs.Add(1)
+	r.reaped.Add(uint64(n))
+	if err != nil {
+		r.failures.Add(1)
+	}
+	r.lastRun.Store(start.UnixNano())
+	r.lastTook.Store(int64(time.Since(start)))
+	return n, err
+}
+
+// close stops the background reaper and waits for a running pass to finish.
+// It is safe to call more than once.
+func (r *reaper) close() {
+	r.once.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
+
+func (r *reaper) stats() ReapStats {
+	st := ReapStats{
+		Runs:         r.runs.Load(),
+		Reaped:       r.reaped.Load(),
+		Failures:     r.failures.Load(),
+		LastDuration: time.Duration(r.lastTook.Load()),
+	}
+	if last := r.lastRun.Load(); last != 0 {
+		st.LastRun = time.Unix(0, last).UTC()
+	}
+	return st
+}
+
+// sameTime reports whether two optional times are both unset or equal.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,89 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpiredUsersAreHidden(t *testing.T) {
+	forEachBackend(t, Options{}, func(t *testing.T, r Repository) {
+		past := time.Now().Add(-time.Minute)
+		soon := time.Now().Add(200 * time.Millisecond)
+		future := time.Now().Add(time.Hour)
+		for _, attrs := range []Attributes{
+			{Name: "Kept", Email: "kept@example.com"},
+			{Name: "Expired", Email: "expired@example.com", ExpiresAt: &past},
+			{Name: "Expiring", Email: "expiring@example.com", ExpiresAt: &future},
+			{Name: "Deleted", Email: "deleted@example.com", ExpiresAt: &soon},
+		} {
+			if _, err := r.Create(attrs); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Delete("2", 0); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("delete of an expired user: err = %v, want ErrNotFound", err)
+		}
+		// User 4 expires after it is deleted, and lists with deleted users
+		// must skip it too.
+		if err := r.Delete("4", 0); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Until(soon))
+
+		if _, err := r.Get("2"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get: err = %v, want ErrNotFound", err)
+		}
+		if _, err := r.GetByEmail("expired@example.com"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetByEmail: err = %v, want ErrNotFound", err)
+		}
+		for _, filter := range []Filter{{}, {ShowDeleted: true}, {NamePrefix: "exp"}} {
+			pages, total := listAll(t, r, 1, filter)
+			want := []string{"1", "3"}
+			if filter.NamePrefix != "" {
+				want = want[1:]
+			}
+			if got := flatten(pages); !reflect.DeepEqual(got, want) || total != len(want) {
+				t.Fatalf("%+v: listed %v (total %d), want %v", filter, got, total, want)
+			}
+		}
+
+		// The email stays taken until the expired user is reaped.
+		if _, err := r.Create(Attributes{Name: "Again", Email: "expired@example.com"}); !errors.Is(err, ErrEmailTaken) {
+			t.Fatalf("create with an unreaped email: err = %v, want ErrEmailTaken", err)
+		}
+		if n, err := r.Reap(); err != nil || n != 2 {
+			t.Fatalf("Reap = %d, %v; want 2", n, err)
+		}
+		if st := r.ReapStats(); st.Runs != 1 || st.Reaped != 2 {
+			t.Fatalf("ReapStats = %+v", st)
+		}
+		if _, err := r.Create(Attributes{Name: "Again", Email: "expired@example.com"}); err != nil {
+			t.Fatalf("create after reaping: %v", err)
+		}
+		users, err := r.List()
+		if err != nil || len(users) != 3 {
+			t.Fatalf("List after reaping = %d users, %v; want 3", len(users), err)
+		}
+	})
+}
+
+func TestReaperRunsInBackground(t *testing.T) {
+	forEachBackend(t, Options{ReapInterval: 5 * time.Millisecond}, func(t *testing.T, r Repository) {
+		soon := time.Now().Add(20 * time.Millisecond)
+		if _, err := r.Create(Attributes{Name: "Brief", Email: "brief@example.com", ExpiresAt: &soon}); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for r.ReapStats().Reaped == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("the reaper did not remove the expired user")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if users, err := r.List(); err != nil || len(users) != 0 {
+			t.Fatalf("List = %d users, %v; want none", len(users), err)
+		}
+	})
+}
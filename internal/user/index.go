@@ -0,0 +1,231 @@
+package user
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Filter narrows List results. All set conditions must match; string
+// comparisons are case-insensitive. BioQuery matches whole words, all of
+// which must appear in the bio.
+//
+// ShowDeleted is not a condition: it adds soft-deleted users to the
+// results, which then match the conditions like any other user.
+type Filter struct {
+	TagsAny     []string
+	TagsAll     []string
+	Email       string
+	NamePrefix  string
+	BioQuery    string
+	ShowDeleted bool
+}
+
+// IsZero reports whether f has no conditions.
+func (f Filter) IsZero() bool {
+	return len(f.TagsAny) == 0 && len(f.TagsAll) == 0 && f.Email == "" && f.NamePrefix == "" && f.BioQuery == ""
+}
+
+type idSet map[int64]struct{}
+
+// index holds the secondary indexes used to answer filtered lists without
+// scanning every user. It is guarded by the Store mutex.
+type index struct {
+	tags  map[string]idSet
+	email map[string]idSet
+	words map[string]idSet
+	// names is ordered by (name, id) so a prefix maps to a contiguous range.
+	names *skipList[nameEntry]
+}
+
+type nameEntry struct {
+	name string
+	id   int64
+}
+
+func newIndex() *index {
+	return &index{
+		tags:  make(map[string]idSet),
+		email: make(map[string]idSet),
+		words: make(map[string]idSet),
+		names: newSkipList(nameEntry.less),
+	}
+}
+
+func (ix *index) add(id int64, attrs Attributes) {
+	for _, tag := range attrs.Tags {
+		addToSet(ix.tags, fold(tag), id)
+	}
+	addToSet(ix.email, fold(attrs.Email), id)
+	for _, word := range words(attrs.Bio) {
+		addToSet(ix.words, word, id)
+	}
+	ix.names.insert(nameEntry{name: fold(attrs.Name), id: id})
+}
+
+func (ix *index) remove(id int64, attrs Attributes) {
+	for _, tag := range attrs.Tags {
+		removeFromSet(ix.tags, fold(tag), id)
+	}
+	removeFromSet(ix.email, fold(attrs.Email), id)
+	for _, word := range words(attrs.Bio) {
+		removeFromSet(ix.words, word, id)
+	}
+	ix.names.remove(nameEntry{name: fold(attrs.Name), id: id})
+}
+
+// update moves id from the entries of old to those of attrs. Only the
+// fields that changed are reindexed, so an update that keeps a long bio
+// does not split it into words again.
+func (ix *index) update(id int64, old, attrs Attributes) {
+	if !slices.Equal(old.Tags, attrs.Tags) {
+		for _, tag := range old.Tags {
+			removeFromSet(ix.tags, fold(tag), id)
+		}
+		for _, tag := range attrs.Tags {
+			addToSet(ix.tags, fold(tag), id)
+		}
+	}
+	if fold(old.Email) != fold(attrs.Email) {
+		removeFromSet(ix.email, fold(old.Email), id)
+		addToSet(ix.email, fold(attrs.Email), id)
+	}
+	if old.Bio != attrs.Bio {
+		for _, word := range words(old.Bio) {
+			removeFromSet(ix.words, word, id)
+		}
+		for _, word := range words(attrs.Bio) {
+			addToSet(ix.words, word, id)
+		}
+	}
+	if name := fold(attrs.Name); fold(old.Name) != name {
+		ix.names.remove(nameEntry{name: fold(old.Name), id: id})
+		ix.names.insert(nameEntry{name: name, id: id})
+	}
+}
+
+// match returns the IDs satisfying a non-zero filter in ascending order.
+func (ix *index) match(f Filter) []int64 {
+	var sets []idSet
+	for _, tag := range f.TagsAll {
+		sets = append(sets, ix.tags[fold(tag)])
+	}
+	if len(f.TagsAny) > 0 {
+		union := make(idSet)
+		for _, tag := range f.TagsAny {
+			for id := range ix.tags[fold(tag)] {
+				union[id] = struct{}{}
+			}
+		}
+		sets = append(sets, union)
+	}
+	if f.Email != "" {
+		sets = append(sets, ix.email[fold(f.Email)])
+	}
+	for _, word := range words(f.BioQuery) {
+		sets = append(sets, ix.words[word])
+	}
+	if f.NamePrefix != "" {
+		sets = append(sets, ix.prefixSet(fold(f.NamePrefix)))
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	// Walk the smallest set and probe the others.
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	ids := make([]int64, 0, len(sets[0]))
+outer:
+	for id := range sets[0] {
+		for _, other := range sets[1:] {
+			if _, ok := other[id]; !ok {
+				continue outer
+			}
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// firstByEmail returns the owner of email. The store keeps emails unique,
+// so the set holds at most one ID.
+func (ix *index) firstByEmail(email string) (int64, bool) {
+	set := ix.email[fold(email)]
+	if len(set) == 0 {
+		return 0, false
+	}
+	first := int64(-1)
+	for id := range set {
+		if first < 0 || id < first {
+			first = id
+		}
+	}
+	return first, true
+}
+
+func (ix *index) prefixSet(prefix string) idSet {
+	set := make(idSet)
+	ix.names.ascend(nameEntry{name: prefix}, func(e nameEntry) bool {
+		if !strings.HasPrefix(e.name, prefix) {
+			return false
+		}
+		set[e.id] = struct{}{}
+		return true
+	})
+	return set
+}
+
+func (e nameEntry) less(o nameEntry) bool {
+	if e.name != o.name {
+		return e.name < o.name
+	}
+	return e.id < o.id
+}
+
+func addToSet(m map[string]idSet, key string, id int64) {
+	if key == "" {
+		return
+	}
+	set, ok := m[key]
+	if !ok {
+		set = make(idSet)
+		m[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+func removeFromSet(m map[string]idSet, key string, id int64) {
+	set, ok := m[key]
+	if !ok {
+		return
+	}
+	delete(set, id)
+	if len(set) == 0 {
+		delete(m, key)
+	}
+}
+
+func fold(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// HasWords reports whether text holds a word a BioQuery can match, i.e. a
+// run of letters or digits.
+func HasWords(text string) bool {
+	return strings.IndexFunc(text, isWordRune) >= 0
+}
+
+// words splits text into its distinct lower-cased letter/digit tokens.
+func words(text string) []string {
+	tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+	slices.Sort(tokens)
+	return slices.Compact(tokens)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,99 @@
+package user
+
+import (
+	"reflect"
+	"testing"
+)
+
+// filterUsers are the users the filter tests list, created in this order
+// so user i gets ID i+1.
+var filterUsers = []Attributes{
+	{Name: "Alice Smith", Email: "alice@example.com", Bio: "Writes Go and Rust.", Tags: []string{"go", "rust"}},
+	{Name: "alan Turing", Email: "alan@example.com", Bio: "Go, go, go!", Tags: []string{"go"}},
+	{Name: "Bob", Email: "bob@example.com", Bio: "Rust only", Tags: []string{"rust", "ops"}},
+	{Name: "Al", Email: "al@example.com", Bio: "", Tags: nil},
+}
+
+func createFilterUsers(t *testing.T, r Repository) {
+	t.Helper()
+	for _, attrs := range filterUsers {
+		if _, err := r.Create(attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListAfterFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"any tag", Filter{TagsAny: []string{"ops", "GO"}}, []string{"1", "2", "3"}},
+		{"all tags", Filter{TagsAll: []string{"go", "rust"}}, []string{"1"}},
+		{"unknown tag", Filter{TagsAll: []string{"go", "java"}}, nil},
+		{"email ignores case", Filter{Email: "BOB@example.com"}, []string{"3"}},
+		{"name prefix ignores case", Filter{NamePrefix: "AL"}, []string{"1", "2", "4"}},
+		{"longer name prefix", Filter{NamePrefix: "ali"}, []string{"1"}},
+		{"bio words", Filter{BioQuery: "go rust"}, []string{"1"}},
+		{"bio word punctuation", Filter{BioQuery: "rust."}, []string{"1", "3"}},
+		{"combined", Filter{TagsAny: []string{"go"}, NamePrefix: "al", BioQuery: "go"}, []string{"1", "2"}},
+	}
+	forEachBackend(t, Options{}, func(t *testing.T, r Repository) {
+		createFilterUsers(t, r)
+		for _, tt := range tests {
+			pages, total := listAll(t, r, 2, tt.filter)
+			if got := flatten(pages); !reflect.DeepEqual(got, tt.want) || total != len(tt.want) {
+				t.Errorf("%s: listed %v (total %d), want %v", tt.name, got, total, tt.want)
+			}
+		}
+	})
+}
+
+func TestListAfterFiltersFollowWrites(t *testing.T) {
+	forEachBackend(t, Options{}, func(t *testing.T, r Repository) {
+		createFilterUsers(t, r)
+		renamed := filterUsers[2]
+		renamed.Name = "Alba"
+		renamed.Bio = "Rust and Zig"
+		renamed.Tags = []string{"zig"}
+		if _, err := r.Update("3", renamed, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Delete("1", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name   string
+			filter Filter
+			want   []string
+		}{
+			{"new name", Filter{NamePrefix: "alb"}, []string{"3"}},
+			{"old name", Filter{NamePrefix: "bob"}, nil},
+			{"new tag", Filter{TagsAny: []string{"zig"}}, []string{"3"}},
+			{"old tag", Filter{TagsAny: []string{"ops"}}, nil},
+			{"kept word", Filter{BioQuery: "rust"}, []string{"3"}},
+			{"new word", Filter{BioQuery: "zig"}, []string{"3"}},
+			{"deleted hidden", Filter{TagsAll: []string{"go"}}, []string{"2"}},
+			{"deleted shown", Filter{TagsAll: []string{"go"}, ShowDeleted: true}, []string{"1", "2"}},
+		}
+		for _, tt := range tests {
+			pages, _ := listAll(t, r, 10, tt.filter)
+			if got := flatten(pages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: listed %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestWords(t *testing.T) {
+	got := words("Go, go, GO! C3PO and-so   on")
+	want := []string{"and", "c3po", "go", "on", "so"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("words = %v, want %v", got, want)
+	}
+	if HasWords(" ?!- ") {
+		t.Fatal("HasWords reports words in punctuation")
+	}
+}
@@ -0,0 +1,66 @@
+package user
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockStats counts lock acquisitions. Contended acquisitions are those that
+// had to wait, and Wait is the total time spent waiting for them.
+type LockStats struct {
+	Acquisitions uint64        `json:"acquisitions"`
+	Contended    uint64        `json:"contended"`
+	Wait         time.Duration `json:"wait_ns"`
+}
+
+// Add returns the sum of two sets of counters.
+func (a LockStats) Add(b LockStats) LockStats {
+	return LockStats{
+		Acquisitions: a.Acquisitions + b.Acquisitions,
+		Contended:    a.Contended + b.Contended,
+		Wait:         a.Wait + b.Wait,
+	}
+}
+
+// instrumentedMutex is a sync.RWMutex that records how long callers wait.
+// The uncontended path is a successful TryLock and never reads the clock.
+type instrumentedMutex struct {
+	sync.RWMutex
+	acquisitions atomic.Uint64
+	contended    atomic.Uint64
+	waitNanos    atomic.Int64
+}
+
+func (m *instrumentedMutex) Lock() {
+	m.acquisitions.Add(1)
+	if m.TryLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.recordWait(start)
+}
+
+func (m *instrumentedMutex) RLock() {
+	m.acquisitions.Add(1)
+	if m.TryRLock() {
+		return
+	}
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.recordWait(start)
+}
+
+func (m *instrumentedMutex) recordWait(start time.Time) {
+	m.contended.Add(1)
+	m.waitNanos.Add(int64(time.Since(start)))
+}
+
+func (m *instrumentedMutex) stats() LockStats {
+	return LockStats{
+		Acquisitions: m.acquisitions.Load(),
+		Contended:    m.contended.Load(),
+		Wait:         time.Duration(m.waitNanos.Load()),
+	}
+}
@@ -0,0 +1,191 @@
+package user
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("user not found")
+	ErrEmailTaken = errors.New("email already in use")
+	// ErrVersionMismatch reports that the user changed since the version
+	// the caller expected.
+	ErrVersionMismatch = errors.New("user version mismatch")
+	// ErrInvalidID reports a restored user whose ID could not have been
+	// allocated by a store.
+	ErrInvalidID = errors.New("invalid user id")
+	// ErrNotDeleted reports an undelete or purge of a user that is not
+	// soft-deleted.
+	ErrNotDeleted = errors.New("user is not deleted")
+	// ErrStoreFull reports a write refused because a bounded Store is at
+	// capacity.
+	ErrStoreFull = errors.New("user store is full")
+)
+
+// Repository is the user storage the service works against. Store keeps
+// users in memory; BoltStore persists them in an embedded bbolt database.
+//
+// Every implementation enforces unique case-insensitive emails, allocates
+// increasing numeric IDs, versions users starting at 1 and treats a zero
+// expectedVersion as "unconditional". Writes take ownership of the Tags and
+// Avatar slices they are given, and returned users may share them with the
+// store; see Attributes.
+//
+// Deleting a user only soft-deletes it: the record is kept with DeletedAt
+// set until it is purged. Soft-deleted users are invisible to every read
+// except lists with Filter.ShowDeleted, and their email is free for other
+// users. Operations on them other than Undelete and Purge fail with
+// ErrNotFound.
+//
+// A user whose ExpiresAt has passed is expired: every read and write treats
+// it as not found, deleted or not, and lists skip it. It keeps its record
+// and its email until Reap removes it for good.
+type Repository interface {
+	Create(attrs Attributes) (User, error)
+	// CreateBatch applies the items in order and reports each outcome.
+	CreateBatch(attrs []Attributes) []BatchItem
+	Get(id string) (User, error)
+	// GetByEmail looks a user up by case-folded email.
+	GetByEmail(email string) (User, error)
+	GetBatch(ids []string) []BatchItem
+	Update(id string, attrs Attributes, expectedVersion int64) (User, error)
+	// Modify replaces the attributes of user id with the result of fn,
+	// atomically with respect to other writes. fn must not modify the
+	// slices it is given; an error from fn aborts the update.
+	Modify(id string, expectedVersion int64, fn func(Attributes) (Attributes, error)) (User, error)
+	// SetAvatar takes ownership of avatar. A non-zero expectedVersion
+	// makes it conditional, as for Update.
+	SetAvatar(id string, avatar []byte, expectedVersion int64) (User, error)
+	// Delete soft-deletes user id.
+	Delete(id string, expectedVersion int64) error
+	// DeleteBatch soft-deletes the users and reports the deleted records.
+	DeleteBatch(ids []string) []BatchItem
+	// Undelete restores soft-deleted user id. It fails with ErrEmailTaken
+	// if another user took the email in the meantime.
+	Undelete(id string, expectedVersion int64) (User, error)
+	// Purge permanently removes soft-deleted user id.
+	Purge(id string, expectedVersion int64) error
+	// List returns every user in ID order, soft-deleted ones included.
+	List() ([]User, error)
+	// Revisions returns up to Options.Revisions versions of user id,
+	// newest first, the current one included. Soft-deleted users keep
+	// their revisions until they are purged.
+	Revisions(id string) ([]Revision, error)
+	// ListAfter returns up to limit users matching filter whose ID is
+	// greater than after, in ID order, and the number of matching users.
+	// When more matches follow, next is the after of the following page;
+	// otherwise it is zero. next comes from the IDs the page selected, so
+	// it holds even if some of them are gone by the time they are read.
+	ListAfter(after int64, limit int, filter Filter) (users []User, next int64, total int, err error)
+	// Restore writes users as given, keeping their IDs, versions and
+	// timestamps. With replace, every other user is removed first. A user
+	// whose email belongs to another user fails with ErrEmailTaken, and
+	// IDs allocated afterwards are greater than every restored ID.
+	Restore(users []User, replace bool) []BatchItem
+	// Reset removes every user and reports how many there were. IDs keep
+	// counting from where they were, so no ID is ever reused.
+	Reset() (int, error)
+	// Reap permanently removes every expired user and reports how many
+	// there were. The background reaper calls it every
+	// Options.ReapInterval.
+	Reap() (int, error)
+	// ReapStats reports the work of Reap so far.
+	ReapStats() ReapStats
+	// Close stops the background reaper and releases the store.
+	Close() error
+}
+
+// Options configures a Repository. The zero value keeps no earlier versions
+// of users and leaves expired users in place until Reap is called.
+type Options struct {
+	// Revisions is how many versions of each user Revisions returns, the
+	// current one included. Stores keep that many earlier versions, so the
+	// oldest returned revision can still be compared with its predecessor.
+	Revisions int
+	// ReapInterval is how often a background reaper removes expired users;
+	// zero disables it. Close stops the reaper.
+	ReapInterval time.Duration
+	// Capacity bounds the in-memory Store; the other stores ignore it.
+	Capacity Capacity
+}
+
+// BatchItem is the outcome of one element of a batch operation.
+type BatchItem struct {
+	User User
+	Err  error
+}
+
+func newUser(id int64, attrs Attributes) User {
+	now := time.Now().UTC()
+	return User{
+		ID:         strconv.FormatInt(id, 10),
+		Attributes: attrs,
+		Version:    1,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+// revise returns the next version of old with attrs.
+func revise(old User, attrs Attributes) User {
+	u := old
+	u.Attributes = attrs
+	u.Version++
+	u.UpdatedAt = time.Now().UTC()
+	return u
+}
+
+// softDelete returns the next version of u, marked as deleted.
+func softDelete(u User) User {
+	now := time.Now().UTC()
+	u.Version++
+	u.UpdatedAt = now
+	u.DeletedAt = &now
+	return u
+}
+
+// undelete returns the next version of u, no longer marked as deleted.
+func undelete(u User) User {
+	u.Version++
+	u.UpdatedAt = time.Now().UTC()
+	u.DeletedAt = nil
+	return u
+}
+
+func checkVersion(u User, expectedVersion int64) error {
+	if expectedVersion != 0 && u.Version != expectedVersion {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// checkState reports whether u is soft-deleted exactly when deleted is set
+// and has not expired. An expired user does not exist either way. A deleted
+// user looked up as a live one does not exist, while a live user
+// looked up as a deleted one is ErrNotDeleted.
+func checkState(u User, deleted bool) error {
+	switch {
+	case u.ExpiresAt != nil && u.Expired(time.Now()):
+		return ErrNotFound
+	case u.Deleted() == deleted:
+		return nil
+	case deleted:
+		return ErrNotDeleted
+	default:
+		return ErrNotFound
+	}
+}
+
+// numericID parses a stored ID. Stored IDs are always formatted from an
+// allocated number, so parsing cannot fail for them.
+func numericID(id string) int64 {
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return n
+}
+
+// validID reports whether id is the canonical form of a positive number.
+func validID(id string) bool {
+	n := numericID(id)
+	return n > 0 && strconv.FormatInt(n, 10) == id
+}
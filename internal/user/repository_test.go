@@ -0,0 +1,168 @@
+package user
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// forEachBackend runs fn as a subtest against every Repository, each one
+// empty and configured with opts.
+func forEachBackend(t *testing.T, opts Options, fn func(t *testing.T, r Repository)) {
+	t.Helper()
+	backends := []struct {
+		name string
+		open func(t *testing.T) Repository
+	}{
+		{"memory", func(*testing.T) Repository { return NewStore(opts) }},
+		{"sharded", func(*testing.T) Repository { return NewShardedStore(4, opts) }},
+		{"bolt", func(t *testing.T) Repository {
+			s, err := OpenBolt(filepath.Join(t.TempDir(), "users.db"), opts)
+			if err != nil {
+				t.Fatalf("OpenBolt: %v", err)
+			}
+			return s
+		}},
+	}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			r := b.open(t)
+			t.Cleanup(func() { r.Close() })
+			fn(t, r)
+		})
+	}
+}
+
+// createUsers creates n users with distinct emails and returns their IDs.
+func createUsers(t *testing.T, r Repository, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := range ids {
+		u, err := r.Create(Attributes{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user-%d@example.com", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = u.ID
+	}
+	return ids
+}
+
+// listAll pages through r with ListAfter and returns the IDs of every page,
+// checking that each page reports the same total.
+func listAll(t *testing.T, r Repository, limit int, filter Filter) (pages [][]string, total int) {
+	t.Helper()
+	var after int64
+	for {
+		users, next, pageTotal, err := r.ListAfter(after, limit, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) > 0 && pageTotal != total {
+			t.Fatalf("page %d total = %d, want %d", len(pages), pageTotal, total)
+		}
+		total = pageTotal
+		ids := make([]string, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		pages = append(pages, ids)
+		if next == 0 {
+			return pages, total
+		}
+		if len(pages) > 100 {
+			t.Fatal("ListAfter does not terminate")
+		}
+		after = next
+	}
+}
+
+func flatten(pages [][]string) []string {
+	var ids []string
+	for _, p := range pages {
+		ids = append(ids, p...)
+	}
+	return ids
+}
+
+func TestListAfterPages(t *testing.T) {
+	forEachBackend(t, Options{}, func(t *testing.T, r Repository) {
+		ids := createUsers(t, r, 12)
+		for _, id := range []string{ids[2], ids[7]} {
+			if err := r.Delete(id, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		live := append(append(append([]string{}, ids[:2]...), ids[3:7]...), ids[8:]...)
+
+		pages, total := listAll(t, r, 5, Filter{})
+		if want := [][]string{live[:5], live[5:]}; !reflect.DeepEqual(pages, want) {
+			t.Fatalf("pages = %v, want %v", pages, want)
+		}
+		if total != len(live) {
+			t.Fatalf("total = %d, want %d", total, len(live))
+		}
+
+		pages, total = listAll(t, r, 4, Filter{ShowDeleted: true})
+		if got := flatten(pages); !reflect.DeepEqual(got, ids) || total != len(ids) {
+			t.Fatalf("with deleted: %v (total %d), want %v", got, total, ids)
+		}
+		if len(pages) != 3 {
+			t.Fatalf("a full last page is followed by %d pages, want none", len(pages)-3)
+		}
+	})
+}
+
+func TestListAfterEmpty(t *testing.T) {
+	forEachBackend(t, Options{}, func(t *testing.T, r Repository) {
+		users, next, total, err := r.ListAfter(0, 10, Filter{})
+		if err != nil || len(users) != 0 || next != 0 || total != 0 {
+			t.Fatalf("ListAfter = %v, %d, %d, %v; want an empty last page", users, next, total, err)
+		}
+	})
+}
+
+// TestListKeepsEveryUser runs the same writes against every backend. List
+// must return the same users from each: soft-deleted and expired ones
+// included, in ID order.
+func TestListKeepsEveryUser(t *testing.T) {
+	type summary struct {
+		ID      string
+		Version int64
+		Deleted bool
+		Expired bool
+	}
+	var results [][]summary
+	forEachBackend(t, Options{}, func(t *testing.T, r Repository) {
+		ids := createUsers(t, r, 9)
+		if err := r.Delete(ids[1], 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Update(ids[2], Attributes{Name: "Renamed", Email: "renamed@example.com"}, 0); err != nil {
+			t.Fatal(err)
+		}
+		past := time.Now().Add(-time.Minute)
+		if _, err := r.Create(Attributes{Name: "Gone", Email: "gone@example.com", ExpiresAt: &past}); err != nil {
+			t.Fatal(err)
+		}
+
+		users, err := r.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []summary
+		for _, u := range users {
+			got = append(got, summary{u.ID, u.Version, u.Deleted(), u.Expired(time.Now())})
+		}
+		results = append(results, got)
+		if len(got) != 10 {
+			t.Fatalf("List returned %d users, want 10: %v", len(got), got)
+		}
+	})
+	for i := 1; i < len(results); i++ {
+		if !reflect.DeepEqual(results[i], results[0]) {
+			t.Fatalf("backends disagree:\n%v\n%v", results[0], results[i])
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package user
+
+import (
+	"bytes"
+	"slices"
+)
+
+// Revision is one kept version of a user. Changed names the fields, by
+// their JSON names, that differ from the previous version. For version 1 it
+// names the fields the user was created with; it is nil when the previous
+// version is no longer known, as for a user restored from a snapshot.
+type Revision struct {
+	User
+	Changed []string `json:"changed_fields"`
+}
+
+// revisions returns up to limit revisions of current, newest first. earlier
+// holds the kept earlier versions, oldest first.
+func revisions(current User, earlier []User, limit int) []Revision {
+	versions := append(slices.Clone(earlier), current)
+	out := make([]Revision, 0, min(len(versions), max(limit, 1)))
+	for i := len(versions) - 1; i >= 0 && len(out) < max(limit, 1); i-- {
+		u := versions[i]
+		rev := Revision{User: u}
+		switch {
+		case i > 0 && versions[i-1].Version == u.Version-1:
+			rev.Changed = changedFields(versions[i-1], u)
+		case u.Version == 1:
+			rev.Changed = changedFields(User{}, u)
+		}
+		out = append(out, rev)
+	}
+	return out
+}
+
+// archive records old, which u replaces, among the earlier versions in
+// history, keeping at most keep of them. A u that is not newer than old, as
+// written by a restore, breaks the chain of versions, so the history is
+// dropped instead.
+func archive(history map[string][]User, old, u User, keep int) {
+	if keep <= 0 {
+		return
+	}
+	if u.Version <= old.Version {
+		delete(history, u.ID)
+		return
+	}
+	h := history[old.ID]
+	if len(h) == keep {
+		copy(h, h[1:])
+		h[len(h)-1] = old
+		return
+	}
+	history[old.ID] = append(h, old)
+}
+
+// changedFields compares two versions of a user attribute by attribute and
+// by deletion state.
+func changedFields(a, b User) []string {
+	changed := []string{}
+	for _, f := range []struct {
+		name string
+		same bool
+	}{
+		{"name", a.Name == b.Name},
+		{"email", a.Email == b.Email},
+		{"phone", a.Phone == b.Phone},
+		{"address", a.Address == b.Address},
+		{"bio", a.Bio == b.Bio},
+		{"tags", slices.Equal(a.Tags, b.Tags)},
+		{"avatar", bytes.Equal(a.Avatar, b.Avatar)},
+		{"expires_at", sameTime(a.ExpiresAt, b.ExpiresAt)},
+		{"deleted_at", a.Deleted() == b.Deleted()},
+	} {
+		if !f.same {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
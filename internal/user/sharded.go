@@ -0,0 +1,554 @@
+package user
+
+import (
+	"container/heap"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ShardedStore is an in-memory Repository that spreads users over
+// independently locked shards by ID, so writers to different users rarely
+// wait for each other. IDs come from an atomic counter, and email ownership
+// lives in its own sharded registry, which keeps emails unique across shards.
+// Only live users own an email; soft-deleting a user releases it.
+//
+// Each user shard carries its own secondary indexes. List and ListAfter hold
+// every shard's read lock at once, so they see a consistent view: no write
+// is half-visible and the users are merged back into ID order.
+type ShardedStore struct {
+	nextID    atomic.Int64
+	shards    []*userShard
+	emails    []*emailShard
+	revisions int
+	reaper    *reaper
+}
+
+type userShard struct {
+	mu    instrumentedMutex
+	users map[string]User
+	// ids holds the shard's IDs in ascending order. Concurrent creates may
+	// finish out of ID order, so inserts search for their position.
+	ids *catalog
+	// history holds the earlier versions of each user, oldest first.
+	history map[string][]User
+}
+
+// emailShard maps case-folded emails to the ID owning them. Its lock is only
+// ever taken after a user shard lock or with no lock held, never before one.
+type emailShard struct {
+	mu     instrumentedMutex
+	owners map[string]int64
+}
+
+var _ Repository = (*ShardedStore)(nil)
+
+// NewShardedStore returns an empty store with the given number of shards,
+// at least one, with its reaper running if opts.ReapInterval is set.
+func NewShardedStore(shards int, opts Options) *ShardedStore {
+	shards = max(shards, 1)
+	s := &ShardedStore{
+		shards:    make([]*userShard, shards),
+		emails:    make([]*emailShard, shards),
+		revisions: max(opts.Revisions, 0),
+	}
+	for i := range s.shards {
+		s.shards[i] = &userShard{users: make(map[string]User), ids: newCatalog(), history: make(map[string][]User)}
+		s.emails[i] = &emailShard{owners: make(map[string]int64)}
+	}
+	s.reaper = newReaper(s.reap)
+	s.reaper.start(opts.ReapInterval)
+	return s
+}
+
+// Create reserves the email before allocating an ID, so a conflicting create
+// does not burn an ID.
+func (s *ShardedStore) Create(attrs Attributes) (User, error) {
+	email := fold(attrs.Email)
+	if !s.reserveEmail(email, 0) {
+		return User{}, ErrEmailTaken
+	}
+	for {
+		n := s.nextID.Add(1)
+		s.setEmailOwner(email, n)
+		if u, ok := s.insert(newUser(n, attrs)); ok {
+			return u, nil
+		}
+		// A concurrent Restore took the ID; allocate another.
+	}
+}
+
+func (s *ShardedStore) insert(u User) (User, bool) {
+	n := numericID(u.ID)
+	sh := s.shardFor(n)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, taken := sh.users[u.ID]; taken {
+		return User{}, false
+	}
+	sh.users[u.ID] = u
+	sh.ids.add(u)
+	return u, true
+}
+
+// CreateBatch creates the users one after another, so a later item cannot
+// reuse an email taken earlier in the batch. Other writers may interleave.
+func (s *ShardedStore) CreateBatch(attrs []Attributes) []BatchItem {
+	items := make([]BatchItem, len(attrs))
+	for i, a := range attrs {
+		items[i].User, items[i].Err = s.Create(a)
+	}
+	return items
+}
+
+func (s *ShardedStore) Get(id string) (User, error) {
+	n := numericID(id)
+	if n <= 0 {
+		return User{}, ErrNotFound
+	}
+	sh := s.shardFor(n)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, checkState(u, false)
+}
+
+// GetByEmail resolves the owner in the email registry and then reads the
+// user. A user that changed its email or was deleted in between is reported
+// as not found.
+func (s *ShardedStore) GetByEmail(email string) (User, error) {
+	email = fold(email)
+	es := s.emailShardFor(email)
+	es.mu.RLock()
+	n, ok := es.owners[email]
+	es.mu.RUnlock()
+	if !ok || n == 0 {
+		return User{}, ErrNotFound
+	}
+	u, err := s.Get(strconv.FormatInt(n, 10))
+	if err != nil || fold(u.Email) != email {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *ShardedStore) GetBatch(ids []string) []BatchItem {
+	items := make([]BatchItem, len(ids))
+	for i, id := range ids {
+		items[i].User, items[i].Err = s.Get(id)
+	}
+	return items
+}
+
+func (s *ShardedStore) Update(id string, attrs Attributes, expectedVersion int64) (User, error) {
+	return s.Modify(id, expectedVersion, func(Attributes) (Attributes, error) { return attrs, nil })
+}
+
+// Modify calls fn under the write lock of the user's shard only.
+func (s *ShardedStore) Modify(id string, expectedVersion int64, fn func(Attributes) (Attributes, error)) (User, error) {
+	return s.mutate(id, expectedVersion, false, func(old User) (User, error) {
+		attrs, err := fn(old.Attributes)
+		if err != nil {
+			return User{}, err
+		}
+		return revise(old, attrs), nil
+	})
+}
+
+func (s *ShardedStore) SetAvatar(id string, avatar []byte, expectedVersion int64) (User, error) {
+	return s.mutate(id, expectedVersion, false, func(old User) (User, error) {
+		u := old
+		u.Avatar = avatar
+		u.Version++
+		u.UpdatedAt = time.Now().UTC()
+		return u, nil
+	})
+}
+
+// mutate replaces user id, which must be soft-deleted exactly when deleted
+// is set, with the result of fn. The email reservation follows the user's
+// live email, so it moves when the email changes and is released or taken
+// back when the user is deleted or undeleted.
+func (s *ShardedStore) mutate(id string, expectedVersion int64, deleted bool, fn func(User) (User, error)) (User, error) {
+	n := numericID(id)
+	if n <= 0 {
+		return User{}, ErrNotFound
+	}
+	sh := s.shardFor(n)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	old, ok := sh.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	if err := checkState(old, deleted); err != nil {
+		return User{}, err
+	}
+	if err := checkVersion(old, expectedVersion); err != nil {
+		return User{}, err
+	}
+	u, err := fn(old)
+	if err != nil {
+		return User{}, err
+	}
+	if !s.moveEmail(liveEmail(old), liveEmail(u), n) {
+		return User{}, ErrEmailTaken
+	}
+	sh.users[id] = u
+	sh.ids.replace(old, u)
+	archive(sh.history, old, u, s.revisions)
+	return u, nil
+}
+
+func (s *ShardedStore) Delete(id string, expectedVersion int64) error {
+	_, err := s.mutate(id, expectedVersion, false, func(old User) (User, error) { return softDelete(old), nil })
+	return err
+}
+
+func (s *ShardedStore) DeleteBatch(ids []string) []BatchItem {
+	items := make([]BatchItem, len(ids))
+	for i, id := range ids {
+		items[i].User, items[i].Err = s.mutate(id, 0, false, func(old User) (User, error) { return softDelete(old), nil })
+	}
+	return items
+}
+
+func (s *ShardedStore) Undelete(id string, expectedVersion int64) (User, error) {
+	return s.mutate(id, expectedVersion, true, func(old User) (User, error) { return undelete(old), nil })
+}
+
+// Purge removes the user from its shard. A soft-deleted user owns no email,
+// so the registry is left alone.
+func (s *ShardedStore) Purge(id string, expectedVersion int64) error {
+	n := numericID(id)
+	if n <= 0 {
+		return ErrNotFound
+	}
+	sh := s.shardFor(n)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u, ok := sh.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkState(u, true); err != nil {
+		return err
+	}
+	if err := checkVersion(u, expectedVersion); err != nil {
+		return err
+	}
+	delete(sh.users, id)
+	delete(sh.history, id)
+	sh.ids.remove(u)
+	return nil
+}
+
+func (s *ShardedStore) Revisions(id string) ([]Revision, error) {
+	n := numericID(id)
+	if n <= 0 {
+		return nil, ErrNotFound
+	}
+	sh := s.shardFor(n)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[id]
+	if !ok || u.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return revisions(u, sh.history[id], s.revisions), nil
+}
+
+// List returns every user in ID order as of a single point in time,
+// soft-deleted and expired ones included.
+func (s *ShardedStore) List() ([]User, error) {
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.RUnlock()
+		}
+	}()
+
+	cursors := make(idCursors, 0, len(s.shards))
+	count := 0
+	for i, sh := range s.shards {
+		if ids := sh.ids.all(); len(ids) > 0 {
+			cursors = append(cursors, idCursor{ids: ids, shard: i})
+			count += len(ids)
+		}
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	users, _ := s.mergeLocked(cursors, count)
+	return users, nil
+}
+
+// ListAfter merges the shards' pages while holding every shard's read
+// lock.
+func (s *ShardedStore) ListAfter(after int64, limit int, filter Filter) (users []User, next int64, total int, err error) {
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.RUnlock()
+		}
+	}()
+
+	now := time.Now()
+	var more bool
+	cursors := make(idCursors, 0, len(s.shards))
+	for i, sh := range s.shards {
+		// The first limit IDs overall are among the first limit of each
+		// shard.
+		ids, shardMore, shardTotal := sh.ids.page(filter, now, after, limit)
+		total += shardTotal
+		more = more || shardMore
+		if len(ids) > 0 {
+			cursors = append(cursors, idCursor{ids: ids, shard: i})
+		}
+	}
+	users, rest := s.mergeLocked(cursors, limit)
+	if (more || rest) && len(users) > 0 {
+		next = numericID(users[len(users)-1].ID)
+	}
+	return users, next, total, nil
+}
+
+// mergeLocked returns the first limit users of the shards' ascending ID
+// lists, in ID order, and whether any IDs were left over. The caller holds
+// the shards' read locks.
+func (s *ShardedStore) mergeLocked(cursors idCursors, limit int) (users []User, rest bool) {
+	heap.Init(&cursors)
+	users = make([]User, 0, min(limit, 64))
+	for len(cursors) > 0 && len(users) < limit {
+		c := &cursors[0]
+		users = append(users, s.shards[c.shard].users[strconv.FormatInt(c.ids[0], 10)])
+		c.ids = c.ids[1:]
+		if len(c.ids) == 0 {
+			heap.Pop(&cursors)
+		} else {
+			heap.Fix(&cursors, 0)
+		}
+	}
+	return users, len(cursors) > 0
+}
+
+// Restore holds every shard's write lock, so lists see either none or all
+// of the restored users.
+func (s *ShardedStore) Restore(users []User, replace bool) []BatchItem {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.Unlock()
+		}
+	}()
+
+	if replace {
+		s.clearLocked()
+	}
+
+	items := make([]BatchItem, len(users))
+	for i, u := range users {
+		if !validID(u.ID) {
+			items[i].Err = ErrInvalidID
+			continue
+		}
+		n := numericID(u.ID)
+		sh := s.shardFor(n)
+		old, existed := sh.users[u.ID]
+		if !s.moveEmail(liveEmail(old), liveEmail(u), n) {
+			items[i].Err = ErrEmailTaken
+			continue
+		}
+		if existed {
+			sh.ids.replace(old, u)
+			archive(sh.history, old, u, s.revisions)
+		} else {
+			sh.ids.add(u)
+		}
+		sh.users[u.ID] = u
+		for next := s.nextID.Load(); next < n && !s.nextID.CompareAndSwap(next, n); next = s.nextID.Load() {
+		}
+		items[i].User = u
+	}
+	return items
+}
+
+func (s *ShardedStore) Reset() (int, error) {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+	}
+	defer func() {
+		for _, sh := range s.shards {
+			sh.mu.Unlock()
+		}
+	}()
+	return s.clearLocked(), nil
+}
+
+// clearLocked empties every shard and releases the users' emails. The
+// caller holds every shard's write lock.
+func (s *ShardedStore) clearLocked() int {
+	n := 0
+	for _, sh := range s.shards {
+		for _, u := range sh.users {
+			s.releaseEmail(fold(u.Email), numericID(u.ID))
+		}
+		n += len(sh.users)
+		sh.users = make(map[string]User)
+		sh.ids = newCatalog()
+		sh.history = make(map[string][]User)
+	}
+	return n
+}
+
+// Reap removes the expired users one shard at a time and releases the
+// emails of those that were live.
+func (s *ShardedStore) Reap() (int, error) {
+	return s.reaper.run()
+}
+
+func (s *ShardedStore) ReapStats() ReapStats {
+	return s.reaper.stats()
+}
+
+func (s *ShardedStore) reap(now time.Time) (int, error) {
+	reaped := 0
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for _, n := range sh.ids.expired(now) {
+			id := strconv.FormatInt(n, 10)
+			u := sh.users[id]
+			if email := liveEmail(u); email != "" {
+				s.releaseEmail(email, n)
+			}
+			delete(sh.users, id)
+			delete(sh.history, id)
+			sh.ids.remove(u)
+			reaped++
+		}
+		sh.mu.Unlock()
+	}
+	return reaped, nil
+}
+
+// Close stops the reaper.
+func (s *ShardedStore) Close() error {
+	s.reaper.close()
+	return nil
+}
+
+// LockStats reports the lock activity of each user shard, followed by the
+// combined activity of the email registry.
+func (s *ShardedStore) LockStats() (shards []LockStats, emails LockStats) {
+	shards = make([]LockStats, len(s.shards))
+	for i, sh := range s.shards {
+		shards[i] = sh.mu.stats()
+	}
+	for _, es := range s.emails {
+		emails = emails.Add(es.mu.stats())
+	}
+	return shards, emails
+}
+
+func (s *ShardedStore) shardFor(n int64) *userShard {
+	return s.shards[uint64(n)%uint64(len(s.shards))]
+}
+
+func (s *ShardedStore) emailShardFor(email string) *emailShard {
+	// FNV-1a; inlined to avoid allocating a hash.Hash per lookup.
+	h := uint32(2166136261)
+	for i := 0; i < len(email); i++ {
+		h ^= uint32(email[i])
+		h *= 16777619
+	}
+	return s.emails[h%uint32(len(s.emails))]
+}
+
+// reserveEmail claims email for id, or for a not yet allocated ID when id is
+// zero. It reports false if another user owns the email.
+func (s *ShardedStore) reserveEmail(email string, id int64) bool {
+	es := s.emailShardFor(email)
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if _, taken := es.owners[email]; taken {
+		return false
+	}
+	es.owners[email] = id
+	return true
+}
+
+func (s *ShardedStore) setEmailOwner(email string, id int64) {
+	es := s.emailShardFor(email)
+	es.mu.Lock()
+	es.owners[email] = id
+	es.mu.Unlock()
+}
+
+// moveEmail moves id's reservation from the email from to the email to,
+// where an empty email stands for none. It reports false, changing nothing,
+// if another user owns to.
+func (s *ShardedStore) moveEmail(from, to string, id int64) bool {
+	if from == to {
+		return true
+	}
+	if to != "" && !s.reserveEmail(to, id) {
+		return false
+	}
+	if from != "" {
+		s.releaseEmail(from, id)
+	}
+	return true
+}
+
+// liveEmail is the email u reserves: none while it is soft-deleted, and
+// none for the zero User.
+func liveEmail(u User) string {
+	if u.ID == "" || u.Deleted() {
+		return ""
+	}
+	return fold(u.Email)
+}
+
+func (s *ShardedStore) releaseEmail(email string, id int64) {
+	es := s.emailShardFor(email)
+	es.mu.Lock()
+	if es.owners[email] == id {
+		delete(es.owners, email)
+	}
+	es.mu.Unlock()
+}
+
+// idCursors is a min-heap of per-shard ascending ID lists, keyed by each
+// list's next ID.
+type idCursors []idCursor
+
+type idCursor struct {
+	ids   []int64
+	shard int
+}
+
+func (h idCursors) Len() int           { return len(h) }
+func (h idCursors) Less(i, j int) bool { return h[i].ids[0] < h[j].ids[0] }
+func (h idCursors) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *idCursors) Push(x any)        { *h = append(*h, x.(idCursor)) }
+func (h *idCursors) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,102 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestShardedEmailsAreUniqueAcrossShards(t *testing.T) {
+	s := NewShardedStore(8, Options{})
+	defer s.Close()
+
+	if _, err := s.Create(Attributes{Name: "Ann", Email: "ann@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// Consecutive IDs land on different shards, so every conflict below
+	// is decided by the email registry rather than a shard's own index.
+	bob, err := s.Create(Attributes{Name: "Bob", Email: "bob@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(Attributes{Name: "Ann", Email: " ANN@example.com"}); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("create with a taken email: err = %v, want ErrEmailTaken", err)
+	}
+	if _, err := s.Update(bob.ID, Attributes{Name: "Bob", Email: "Ann@Example.com"}, 0); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("update to a taken email: err = %v, want ErrEmailTaken", err)
+	}
+
+	if err := s.Delete("1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Update(bob.ID, Attributes{Name: "Bob", Email: "ann@example.com"}, 0); err != nil {
+		t.Fatalf("update to the email of a deleted user: %v", err)
+	}
+	if _, err := s.Undelete("1", 0); !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("undelete after the email was taken: err = %v, want ErrEmailTaken", err)
+	}
+	if _, err := s.Create(Attributes{Name: "Bob", Email: "bob@example.com"}); err != nil {
+		t.Fatalf("create with the email Bob gave up: %v", err)
+	}
+}
+
+func TestShardedConcurrentCreates(t *testing.T) {
+	s := NewShardedStore(4, Options{})
+	defer s.Close()
+
+	const workers, perWorker = 8, 50
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		wins   int
+		losses int
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				_, err := s.Create(Attributes{Name: "User", Email: fmt.Sprintf("user-%d-%d@example.com", w, i)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// Every worker also races for the same shared email.
+				_, shared := s.Create(Attributes{Name: "Shared", Email: fmt.Sprintf("shared-%d@example.com", i)})
+				mu.Lock()
+				if shared == nil {
+					wins++
+				} else if errors.Is(shared, ErrEmailTaken) {
+					losses++
+				}
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if wins != perWorker || losses != (workers-1)*perWorker {
+		t.Fatalf("shared emails: %d created and %d refused, want %d and %d", wins, losses, perWorker, (workers-1)*perWorker)
+	}
+	users, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != workers*perWorker+perWorker {
+		t.Fatalf("List returned %d users, want %d", len(users), workers*perWorker+perWorker)
+	}
+	if !sort.SliceIsSorted(users, func(i, j int) bool { return numericID(users[i].ID) < numericID(users[j].ID) }) {
+		t.Fatal("List is not in ID order")
+	}
+	pages, total := listAll(t, s, 7, Filter{})
+	if got := flatten(pages); len(got) != len(users) || total != len(users) {
+		t.Fatalf("paged list returned %d users (total %d), want %d", len(got), total, len(users))
+	}
+	for i, id := range flatten(pages) {
+		if id != users[i].ID {
+			t.Fatalf("page entry %d is user %s, want %s", i, id, users[i].ID)
+		}
+	}
+}
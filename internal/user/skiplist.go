@@ -0,0 +1,113 @@
+package user
+
+import "math/rand"
+
+// skipMaxLevel bounds the height of a skipList; with a quarter of the nodes
+// promoted to each next level it covers far more entries than a store holds.
+const skipMaxLevel = 24
+
+// skipList is an ordered set with logarithmic inserts, removals and seeks,
+// so keeping IDs or names sorted never moves the entries after the one
+// that changed. It is not safe for concurrent writes; its owner's lock
+// guards it.
+type skipList[T any] struct {
+	less  func(a, b T) bool
+	head  skipNode[T]
+	level int
+	n     int
+}
+
+type skipNode[T any] struct {
+	value T
+	next  []*skipNode[T]
+}
+
+func newSkipList[T any](less func(a, b T) bool) *skipList[T] {
+	l := &skipList[T]{less: less}
+	l.clear()
+	return l
+}
+
+func (l *skipList[T]) clear() {
+	l.head.next = make([]*skipNode[T], skipMaxLevel)
+	l.level, l.n = 1, 0
+}
+
+func (l *skipList[T]) len() int {
+	return l.n
+}
+
+// seek returns the first node not less than v. When prev is given, it
+// receives the last node before v on every level.
+func (l *skipList[T]) seek(v T, prev []*skipNode[T]) *skipNode[T] {
+	x := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && l.less(x.next[i].value, v) {
+			x = x.next[i]
+		}
+		if prev != nil {
+			prev[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+func (l *skipList[T]) contains(v T) bool {
+	x := l.seek(v, nil)
+	return x != nil && !l.less(v, x.value)
+}
+
+// insert adds v and reports whether it was missing.
+func (l *skipList[T]) insert(v T) bool {
+	var prev [skipMaxLevel]*skipNode[T]
+	if x := l.seek(v, prev[:]); x != nil && !l.less(v, x.value) {
+		return false
+	}
+	level := 1
+	for level < skipMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	for ; l.level < level; l.level++ {
+		prev[l.level] = &l.head
+	}
+	node := &skipNode[T]{value: v, next: make([]*skipNode[T], level)}
+	for i := range node.next {
+		node.next[i] = prev[i].next[i]
+		prev[i].next[i] = node
+	}
+	l.n++
+	return true
+}
+
+// remove deletes v and reports whether it was present.
+func (l *skipList[T]) remove(v T) bool {
+	var prev [skipMaxLevel]*skipNode[T]
+	x := l.seek(v, prev[:])
+	if x == nil || l.less(v, x.value) {
+		return false
+	}
+	for i, next := range x.next {
+		prev[i].next[i] = next
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.n--
+	return true
+}
+
+// ascend calls fn for every value not less than from, in order, until fn
+// returns false.
+func (l *skipList[T]) ascend(from T, fn func(T) bool) {
+	for x := l.seek(from, nil); x != nil && fn(x.value); x = x.next[0] {
+	}
+}
+
+// values returns every value in order.
+func (l *skipList[T]) values() []T {
+	values := make([]T, 0, l.n)
+	for x := l.head.next[0]; x != nil; x = x.next[0] {
+		values = append(values, x.value)
+	}
+	return values
+}
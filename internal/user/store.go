@@ -1,103 +1,546 @@
 package user
 
 import (
-	"errors"
-	"sort"
+	"fmt"
 	"strconv"
 	"sync"
+	"time"
 )
 
-var (
-	ErrNotFound = errors.New("user not found")
-)
-
+// Store is the in-memory Repository. Opened with OpenDurable, it also logs
+// every change to a write-ahead log.
 type Store struct {
-	mu     sync.RWMutex
+	mu     instrumentedMutex
 	users  map[string]User
 	nextID int64
+	// ids holds the numeric IDs of all users in ascending order, with
+	// their secondary indexes.
+	ids *catalog
+	// history holds the earlier versions of each user, oldest first.
+	history   map[string][]User
+	revisions int
+	reaper    *reaper
+	// usage is nil unless the store is bounded.
+	usage *usage
+
+	// wal is nil unless the store is durable. Records are appended under
+	// mu, so the log order is the order changes were applied in.
+	wal    *walWriter
+	snapMu sync.Mutex
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+var _ Repository = (*Store)(nil)
+
+// NewStore returns an empty store, with its reaper running if
+// opts.ReapInterval is set.
+func NewStore(opts Options) *Store {
+	s := newStore(opts)
+	s.reaper.start(opts.ReapInterval)
+	return s
 }
 
-func NewStore() *Store {
-	return &Store{
-		users: make(map[string]User),
+func newStore(opts Options) *Store {
+	s := &Store{
+		users:     make(map[string]User),
+		ids:       newCatalog(),
+		history:   make(map[string][]User),
+		revisions: max(opts.Revisions, 0),
 	}
+	s.reaper = newReaper(s.reap)
+	if opts.Capacity.bounded() {
+		s.usage = newUsage(opts.Capacity)
+	}
+	return s
 }
 
-func (s *Store) Create(attrs Attributes) User {
+// Create stores a new user. Emails are unique ignoring case, so creating a
+// second user with a taken address fails with ErrEmailTaken.
+func (s *Store) Create(attrs Attributes) (User, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.nextID++
-	id := strconv.FormatInt(s.nextID, 10)
-	u := User{
-		ID:         id,
-		Attributes: cloneAttributes(attrs),
+	return s.insertLocked(attrs)
+}
+
+// Update replaces the attributes of user id. A non-zero expectedVersion
+// makes the update conditional: it fails with ErrVersionMismatch unless the
+// stored user is still at that version.
+func (s *Store) Update(id string, attrs Attributes, expectedVersion int64) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.lookupLocked(id, expectedVersion, false)
+	if err != nil {
+		return User{}, err
 	}
-	s.users[id] = u
-	return u
+	return s.updateLocked(old, attrs)
+}
+
+// Modify replaces the attributes of user id with the result of fn, called
+// with the current attributes under the write lock, so concurrent partial
+// updates cannot overwrite each other. fn must not modify the slices it is
+// given; an error from fn aborts the update. expectedVersion works as in
+// Update.
+func (s *Store) Modify(id string, expectedVersion int64, fn func(Attributes) (Attributes, error)) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.lookupLocked(id, expectedVersion, false)
+	if err != nil {
+		return User{}, err
+	}
+	attrs, err := fn(old.Attributes)
+	if err != nil {
+		return User{}, err
+	}
+	return s.updateLocked(old, attrs)
 }
 
-func (s *Store) Update(id string, attrs Attributes) (User, error) {
+// SetAvatar replaces the avatar of an existing user. The store takes
+// ownership of avatar, so callers must not modify it afterwards.
+func (s *Store) SetAvatar(id string, avatar []byte, expectedVersion int64) (User, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, ok := s.users[id]
+	u, err := s.lookupLocked(id, expectedVersion, false)
+	if err != nil {
+		return User{}, err
+	}
+	old := u
+	u.Avatar = avatar
+	u.Version++
+	u.UpdatedAt = time.Now().UTC()
+	victims, err := s.planRoomLocked(id, 0, s.growthLocked(old, u))
+	if err != nil {
+		return User{}, err
+	}
+	if err := s.logLocked(walRecord{Op: walPut, User: &u}); err != nil {
+		return User{}, err
+	}
+	if err := s.evictLocked(victims); err != nil {
+		return User{}, err
+	}
+	s.users[id] = u
+	archive(s.history, old, u, s.revisions)
+	s.accountLocked(id)
+	return u, nil
+}
+
+func (s *Store) Get(id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lookupLocked(id, 0, false)
+}
+
+// GetByEmail looks a user up by case-folded email through the email index.
+func (s *Store) GetByEmail(email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.ids.emailOwner(email)
 	if !ok {
 		return User{}, ErrNotFound
 	}
-	u := User{
-		ID:         id,
-		Attributes: cloneAttributes(attrs),
+	u := s.users[strconv.FormatInt(id, 10)]
+	if err := checkState(u, false); err != nil {
+		return User{}, err
+	}
+	if s.usage != nil {
+		s.usage.touch(u.ID)
 	}
-	s.users[id] = u
 	return u, nil
 }
 
-func (s *Store) Get(id string) (User, bool) {
+// Delete soft-deletes user id. expectedVersion works as in Update.
+func (s *Store) Delete(id string, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.deleteLocked(id, expectedVersion)
+	return err
+}
+
+// Undelete restores soft-deleted user id. expectedVersion works as in
+// Update.
+func (s *Store) Undelete(id string, expectedVersion int64) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.lookupLocked(id, expectedVersion, true)
+	if err != nil {
+		return User{}, err
+	}
+	if _, taken := s.ids.emailOwner(old.Email); taken {
+		return User{}, ErrEmailTaken
+	}
+	return s.replaceLocked(old, undelete(old), nil)
+}
+
+// Purge permanently removes soft-deleted user id. expectedVersion works as
+// in Update.
+func (s *Store) Purge(id string, expectedVersion int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.lookupLocked(id, expectedVersion, true); err != nil {
+		return err
+	}
+	_, err := s.removeLocked(id)
+	return err
+}
+
+// CreateBatch creates all users under a single lock acquisition. Items are
+// applied in order, so a later item cannot reuse an email taken earlier in
+// the same batch.
+func (s *Store) CreateBatch(attrs []Attributes) []BatchItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]BatchItem, len(attrs))
+	for i, a := range attrs {
+		items[i].User, items[i].Err = s.insertLocked(a)
+	}
+	return items
+}
+
+func (s *Store) GetBatch(ids []string) []BatchItem {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]BatchItem, len(ids))
+	for i, id := range ids {
+		items[i].User, items[i].Err = s.lookupLocked(id, 0, false)
+	}
+	return items
+}
+
+// DeleteBatch soft-deletes the users and reports the deleted records.
+func (s *Store) DeleteBatch(ids []string) []BatchItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]BatchItem, len(ids))
+	for i, id := range ids {
+		items[i].User, items[i].Err = s.deleteLocked(id, 0)
+	}
+	return items
+}
+
+// List returns every user ordered by numeric ID, i.e. creation order,
+// soft-deleted ones included.
+func (s *Store) List() ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.ids.all()
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	users := make([]User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, s.users[strconv.FormatInt(id, 10)])
+	}
+	return users, nil
+}
+
+// Revisions returns the current version of user id followed by the kept
+// earlier ones.
+func (s *Store) Revisions(id string) ([]Revision, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	u, ok := s.users[id]
-	return u, ok
+	if !ok || u.Expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return revisions(u, s.history[id], s.revisions), nil
+}
+
+// ListAfter returns up to limit users matching filter whose ID is greater
+// than after, in ID order, and the after of the next page, or zero on the
+// last one. An after of zero starts at the beginning. total is the number
+// of matching users. Filtered lists are answered from the secondary
+// indexes.
+func (s *Store) ListAfter(after int64, limit int, filter Filter) (users []User, next int64, total int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids, more, total := s.ids.page(filter, time.Now(), after, limit)
+	users = make([]User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, s.users[strconv.FormatInt(id, 10)])
+	}
+	return users, nextAfter(ids, more), total, nil
+}
+
+// Restore applies users under a single lock acquisition, so readers see
+// either none or all of them.
+func (s *Store) Restore(users []User, replace bool) []BatchItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]BatchItem, len(users))
+	if replace {
+		if err := s.logLocked(walRecord{Op: walReset}); err != nil {
+			for i := range items {
+				items[i].Err = err
+			}
+			return items
+		}
+		s.resetLocked()
+	}
+	for i := range users {
+		u := users[i]
+		if !validID(u.ID) {
+			items[i].Err = ErrInvalidID
+			continue
+		}
+		if owner, taken := s.ids.emailOwner(u.Email); taken && owner != numericID(u.ID) && !u.Deleted() {
+			items[i].Err = ErrEmailTaken
+			continue
+		}
+		rec := walRecord{Op: walPut, User: &u}
+		if err := s.logLocked(rec); err != nil {
+			items[i].Err = err
+			continue
+		}
+		if err := s.applyLocked(rec); err != nil {
+			items[i].Err = err
+			continue
+		}
+		items[i].User = u
+	}
+	return items
+}
+
+// Reap removes the expired users under a single lock acquisition. Each
+// removal is logged like a purge.
+func (s *Store) Reap() (int, error) {
+	return s.reaper.run()
+}
+
+func (s *Store) ReapStats() ReapStats {
+	return s.reaper.stats()
+}
+
+// LockStats reports the activity of the store's lock.
+func (s *Store) LockStats() LockStats {
+	return s.mu.stats()
+}
+
+func (s *Store) reap(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reaped := 0
+	for _, n := range s.ids.expired(now) {
+		if _, err := s.removeLocked(strconv.FormatInt(n, 10)); err != nil {
+			return reaped, err
+		}
+		reaped++
+	}
+	return reaped, nil
+}
+
+// Close stops the reaper and the background fsync and snapshot loops, and
+// syncs the WAL.
+func (s *Store) Close() error {
+	s.reaper.close()
+	if s.wal == nil {
+		return nil
+	}
+	close(s.stop)
+	s.wg.Wait()
+	return s.wal.close()
+}
+
+func (s *Store) insertLocked(attrs Attributes) (User, error) {
+	if _, taken := s.ids.emailOwner(attrs.Email); taken {
+		return User{}, ErrEmailTaken
+	}
+
+	n := s.nextID + 1
+	u := newUser(n, attrs)
+	victims, err := s.planRoomLocked("", 1, userSize(u))
+	if err != nil {
+		return User{}, err
+	}
+	if err := s.logLocked(walRecord{Op: walPut, User: &u}); err != nil {
+		return User{}, err
+	}
+	s.nextID = n
+	if err := s.evictLocked(victims); err != nil {
+		return User{}, err
+	}
+	s.users[u.ID] = u
+	s.ids.add(u)
+	s.accountLocked(u.ID)
+	return u, nil
+}
+
+// lookupLocked returns user id, which must be soft-deleted exactly when
+// deleted is set, checking expectedVersion unless it is zero.
+func (s *Store) lookupLocked(id string, expectedVersion int64, deleted bool) (User, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	if err := checkState(u, deleted); err != nil {
+		return User{}, err
+	}
+	if err := checkVersion(u, expectedVersion); err != nil {
+		return User{}, err
+	}
+	if s.usage != nil {
+		s.usage.touch(id)
+	}
+	return u, nil
+}
+
+func (s *Store) updateLocked(old User, attrs Attributes) (User, error) {
+	if owner, taken := s.ids.emailOwner(attrs.Email); taken && owner != numericID(old.ID) {
+		return User{}, ErrEmailTaken
+	}
+	u := revise(old, attrs)
+	victims, err := s.planRoomLocked(old.ID, 0, s.growthLocked(old, u))
+	if err != nil {
+		return User{}, err
+	}
+	return s.replaceLocked(old, u, victims)
 }
 
-func (s *Store) Delete(id string) bool {
+func (s *Store) deleteLocked(id string, expectedVersion int64) (User, error) {
+	old, err := s.lookupLocked(id, expectedVersion, false)
+	if err != nil {
+		return User{}, err
+	}
+	return s.replaceLocked(old, softDelete(old), nil)
+}
+
+// replaceLocked logs and stores u, the next version of old, evicting the
+// victims planned to make room for it once the write is logged.
+func (s *Store) replaceLocked(old, u User, victims []string) (User, error) {
+	if err := s.logLocked(walRecord{Op: walPut, User: &u}); err != nil {
+		return User{}, err
+	}
+	if err := s.evictLocked(victims); err != nil {
+		return User{}, err
+	}
+	s.users[u.ID] = u
+	s.ids.replace(old, u)
+	archive(s.history, old, u, s.revisions)
+	s.accountLocked(u.ID)
+	return u, nil
+}
+
+func (s *Store) Reset() (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, ok := s.users[id]; !ok {
-		return false
+	if err := s.logLocked(walRecord{Op: walReset}); err != nil {
+		return 0, err
+	}
+	n := len(s.users)
+	s.resetLocked()
+	return n, nil
+}
+
+// resetLocked removes every user. nextID is kept, so IDs are never reused.
+func (s *Store) resetLocked() {
+	s.users = make(map[string]User)
+	s.ids = newCatalog()
+	s.history = make(map[string][]User)
+	if s.usage != nil {
+		s.usage.clear()
+	}
+}
+
+// removeLocked logs and applies the permanent removal of user id.
+func (s *Store) removeLocked(id string) (User, error) {
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	if err := s.logLocked(walRecord{Op: walDelete, ID: id}); err != nil {
+		return User{}, err
 	}
 	delete(s.users, id)
-	return true
+	delete(s.history, id)
+	s.ids.remove(u)
+	if s.usage != nil {
+		s.usage.drop(id)
+	}
+	return u, nil
 }
 
-func (s *Store) List() []User {
+// CapacityStats reports the store's use of its capacity. An unbounded store
+// only reports its number of users.
+func (s *Store) CapacityStats() CapacityStats {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(s.users) == 0 {
-		return nil
+	if s.usage == nil {
+		return CapacityStats{Users: len(s.users)}
+	}
+	return s.usage.stats(len(s.users))
+}
+
+// planRoomLocked checks that the store can hold users more users and grow
+// by grow bytes, returning the users other than keep that EvictLRU must
+// purge first. Nothing is purged yet: the caller logs its write and only
+// then passes the victims to evictLocked, so a write that cannot fit or
+// cannot be logged evicts nobody.
+func (s *Store) planRoomLocked(keep string, users int, grow int64) ([]string, error) {
+	if s.usage == nil {
+		return nil, nil
+	}
+	victims, ok := s.usage.plan(keep, len(s.users)+users, grow)
+	if !ok {
+		s.usage.rejected.Add(1)
+		return nil, ErrStoreFull
 	}
+	return victims, nil
+}
 
-	users := make([]User, 0, len(s.users))
-	for _, u := range s.users {
-		users = append(users, u)
+// evictLocked purges the victims chosen by planRoomLocked.
+func (s *Store) evictLocked(victims []string) error {
+	for _, id := range victims {
+		if _, err := s.removeLocked(id); err != nil {
+			return fmt.Errorf("evict user %s: %w", id, err)
+		}
+		s.usage.evicted.Add(1)
 	}
+	return nil
+}
 
-	sort.Slice(users, func(i, j int) bool {
-		return users[i].ID < users[j].ID
-	})
-	return users
+// growthLocked estimates how many bytes replacing old with its next version
+// u adds: u itself, with old moving into the history and the oldest kept
+// version dropping out once the history is full.
+func (s *Store) growthLocked(old, u User) int64 {
+	if s.revisions == 0 {
+		return userSize(u) - userSize(old)
+	}
+	grow := userSize(u)
+	if h := s.history[old.ID]; len(h) == s.revisions {
+		grow -= userSize(h[0])
+	}
+	return grow
 }
 
-func cloneAttributes(attrs Attributes) Attributes {
-	copied := attrs
-	if attrs.Tags != nil {
-		copied.Tags = append([]string(nil), attrs.Tags...)
+// accountLocked records the size of user id, which was just written, and
+// marks it as most recently used.
+func (s *Store) accountLocked(id string) {
+	if s.usage == nil {
+		return
 	}
-	if attrs.Avatar != nil {
-		copied.Avatar = append([]byte(nil), attrs.Avatar...)
+	u := s.users[id]
+	size := userSize(u)
+	for _, old := range s.history[id] {
+		size += userSize(old)
 	}
-	return copied
+	s.usage.set(id, size, u.Deleted())
 }
@@ -1,5 +1,11 @@
 package user
 
+import "time"
+
+// Attributes are the user-editable fields. Tags and Avatar are immutable
+// once handed to a Repository: stores keep the slices they are given and
+// return them to every reader instead of copying, so no one may modify them
+// afterwards.
 type Attributes struct {
 	Name    string   `json:"name"`
 	Email   string   `json:"email"`
@@ -8,9 +14,28 @@ type Attributes struct {
 	Bio     string   `json:"bio"`
 	Tags    []string `json:"tags"`
 	Avatar  []byte   `json:"avatar"`
+	// ExpiresAt, when set, is when the user expires; see Repository.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
+// User is a stored user. Version starts at 1 and increases with every
+// change, so callers can detect concurrent writes. DeletedAt is set while
+// the user is soft-deleted.
 type User struct {
 	ID string `json:"id"`
 	Attributes
+	Version   int64      `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Deleted reports whether u is soft-deleted.
+func (u User) Deleted() bool {
+	return u.DeletedAt != nil
+}
+
+// Expired reports whether u has expired at now.
+func (u User) Expired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
 }
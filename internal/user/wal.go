@@ -0,0 +1,519 @@
+package user
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCorrupt reports a damaged WAL or snapshot that cannot be replayed.
+var ErrCorrupt = errors.New("corrupt store data")
+
+// Fsync policies for the write-ahead log.
+const (
+	// FsyncAlways syncs every record before the write is acknowledged.
+	FsyncAlways = "always"
+	// FsyncInterval syncs in the background every FsyncInterval, so a crash
+	// loses at most that much acknowledged work.
+	FsyncInterval = "interval"
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever = "never"
+)
+
+// DurabilityOptions configures OpenDurable. Zero intervals disable the
+// background fsync and snapshot loops respectively.
+type DurabilityOptions struct {
+	Fsync            string
+	FsyncInterval    time.Duration
+	SnapshotInterval time.Duration
+}
+
+const (
+	snapshotFile = "snapshot"
+	walPrefix    = "wal-"
+
+	walPut = "put"
+	// walDelete purges a user. Soft deletes and undeletes are puts.
+	walDelete = "delete"
+	// walReset removes every user. IDs keep counting from where they were.
+	walReset = "reset"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is one logged change. Puts carry the whole user, so replay does
+// not depend on how the change was computed.
+type walRecord struct {
+	Op   string `json:"op"`
+	User *User  `json:"user,omitempty"`
+	ID   string `json:"id,omitempty"`
+}
+
+// snapshotHeader starts a snapshot. WAL is the first log segment written
+// after the snapshot was taken. Users counts the user records that follow;
+// the kept earlier versions of a user precede its current one, so loading
+// the records in order rebuilds the revision history.
+type snapshotHeader struct {
+	NextID int64  `json:"next_id"`
+	WAL    uint64 `json:"wal"`
+	Users  int    `json:"users"`
+}
+
+// OpenDurable returns an in-memory Store that logs every change to a
+// write-ahead log in dir and periodically snapshots its contents there. On
+// open, the latest snapshot is loaded and the log replayed on top of it. A
+// torn record at the end of the log, as left by a crash mid-write, is
+// dropped; any other damage fails with ErrCorrupt rather than silently
+// losing users.
+func OpenDurable(dir string, opts Options, durability DurabilityOptions) (*Store, error) {
+	switch durability.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return nil, fmt.Errorf("unknown fsync policy %q", durability.Fsync)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := newStore(opts)
+	seq, err := s.loadSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, segment := range segments {
+		if segment < seq {
+			continue
+		}
+		if err := s.replay(filepath.Join(dir, walName(segment)), i == len(segments)-1); err != nil {
+			return nil, err
+		}
+		seq = segment
+	}
+
+	s.wal, err = openWAL(dir, seq, durability.Fsync == FsyncAlways)
+	if err != nil {
+		return nil, err
+	}
+	s.stop = make(chan struct{})
+	if durability.Fsync == FsyncInterval && durability.FsyncInterval > 0 {
+		s.every(durability.FsyncInterval, func() {
+			if err := s.wal.sync(); err != nil {
+				log.Printf("wal fsync failed: %v", err)
+			}
+		})
+	}
+	if durability.SnapshotInterval > 0 {
+		s.every(durability.SnapshotInterval, func() {
+			if err := s.Snapshot(); err != nil {
+				log.Printf("snapshot failed: %v", err)
+			}
+		})
+	}
+	// Only reap once the log is open, so every removal is logged.
+	s.reaper.start(opts.ReapInterval)
+	return s, nil
+}
+
+// Snapshot writes the full store to disk and discards the log segments it
+// supersedes. Writers are blocked only while the users are collected.
+func (s *Store) Snapshot() error {
+	if s.wal == nil {
+		return nil
+	}
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	s.mu.Lock()
+	ids := s.ids.all()
+	users := make([]User, 0, len(ids))
+	for _, n := range ids {
+		id := strconv.FormatInt(n, 10)
+		users = append(users, s.history[id]...)
+		users = append(users, s.users[id])
+	}
+	header := snapshotHeader{NextID: s.nextID, Users: len(users)}
+	seq, err := s.wal.rotate()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	header.WAL = seq
+
+	if err := writeSnapshot(filepath.Join(s.wal.dir, snapshotFile), header, users); err != nil {
+		return err
+	}
+	segments, err := walSegments(s.wal.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment < seq {
+			if err := os.Remove(filepath.Join(s.wal.dir, walName(segment))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) every(interval time.Duration, fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// logLocked appends rec to the WAL, if there is one, before the change is
+// applied, so a failed write leaves the store unchanged.
+func (s *Store) logLocked(rec walRecord) error {
+	if s.wal == nil {
+		return nil
+	}
+	return s.wal.append(rec)
+}
+
+// applyLocked replays rec without logging it again.
+func (s *Store) applyLocked(rec walRecord) error {
+	switch rec.Op {
+	case walPut:
+		if rec.User == nil {
+			return fmt.Errorf("%w: put without user", ErrCorrupt)
+		}
+		u := *rec.User
+		n := numericID(u.ID)
+		if n <= 0 {
+			return fmt.Errorf("%w: invalid user id %q", ErrCorrupt, u.ID)
+		}
+		if old, ok := s.users[u.ID]; ok {
+			s.ids.replace(old, u)
+			archive(s.history, old, u, s.revisions)
+		} else {
+			s.ids.add(u)
+		}
+		s.users[u.ID] = u
+		s.nextID = max(s.nextID, n)
+		s.accountLocked(u.ID)
+	case walDelete:
+		if u, ok := s.users[rec.ID]; ok {
+			delete(s.users, rec.ID)
+			delete(s.history, rec.ID)
+			s.ids.remove(u)
+			if s.usage != nil {
+				s.usage.drop(rec.ID)
+			}
+		}
+	case walReset:
+		s.resetLocked()
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrCorrupt, rec.Op)
+	}
+	return nil
+}
+
+func (s *Store) loadSnapshot(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var header snapshotHeader
+	if err := readFrame(r, &header); err != nil {
+		return 0, fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	for i := 0; i < header.Users; i++ {
+		var u User
+		if err := readFrame(r, &u); err != nil {
+			return 0, fmt.Errorf("snapshot %s: %w", path, err)
+		}
+		if err := s.applyLocked(walRecord{Op: walPut, User: &u}); err != nil {
+			return 0, err
+		}
+	}
+	s.nextID = max(s.nextID, header.NextID)
+	return header.WAL, nil
+}
+
+// replay applies the records of one log segment. In the last segment, a
+// damaged record that reaches the end of the file is a torn write and is
+// truncated away. Earlier segments were synced before the log moved on, so
+// any damage to them is corruption.
+func (s *Store) replay(path string, last bool) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := &countingReader{r: bufio.NewReader(f)}
+	for {
+		start := r.n
+		var rec walRecord
+		err := readFrame(r, &rec)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			// The input ended inside the frame, or the last frame of the
+			// segment was only partly written before a crash. Damage
+			// anywhere else is corruption.
+			torn := errors.Is(err, errTornFrame) || errors.Is(err, errChecksum) && r.n == info.Size()
+			if torn && last {
+				log.Printf("wal %s: dropping torn record at offset %d", path, start)
+				return f.Truncate(start)
+			}
+			if !errors.Is(err, ErrCorrupt) {
+				err = fmt.Errorf("%w: %w", ErrCorrupt, err)
+			}
+			return fmt.Errorf("wal %s at offset %d: %w", path, start, err)
+		}
+		if err := s.applyLocked(rec); err != nil {
+			return fmt.Errorf("wal %s at offset %d: %w", path, start, err)
+		}
+	}
+}
+
+// walWriter appends framed records to the current log segment.
+type walWriter struct {
+	dir        string
+	syncAlways bool
+
+	mu    sync.Mutex
+	seq   uint64
+	f     *os.File
+	dirty bool
+}
+
+func openWAL(dir string, seq uint64, syncAlways bool) (*walWriter, error) {
+	w := &walWriter{dir: dir, syncAlways: syncAlways, seq: seq}
+	f, err := os.OpenFile(filepath.Join(dir, walName(seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w.f = f
+	return w, nil
+}
+
+func (w *walWriter) append(rec walRecord) error {
+	frame, err := encodeFrame(rec)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.f.Write(frame); err != nil {
+		return err
+	}
+	if w.syncAlways {
+		return w.f.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+func (w *walWriter) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+// rotate syncs and closes the current segment and starts the next one,
+// returning its sequence number.
+func (w *walWriter) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(w.dir, walName(w.seq+1)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	w.f.Close()
+	w.f, w.seq, w.dirty = f, w.seq+1, false
+	return w.seq, nil
+}
+
+func (w *walWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+func writeSnapshot(path string, header snapshotHeader, users []User) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = writeFrame(w, header)
+	for i := 0; err == nil && i < len(users); i++ {
+		err = writeFrame(w, users[i])
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func walName(seq uint64) string {
+	return fmt.Sprintf("%s%016d", walPrefix, seq)
+}
+
+// walSegments lists the log segment numbers in dir in ascending order.
+func walSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), walPrefix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimPrefix(e.Name(), walPrefix), 10, 64)
+		if err == nil {
+			segments = append(segments, seq)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// Frames are a 4-byte big-endian payload length, a 4-byte CRC-32C of that
+// length, a 4-byte CRC-32C of the payload and the JSON payload itself. The
+// length has a checksum of its own, so a damaged length is reported as
+// corruption instead of looking like a frame that runs past the end of the
+// file.
+const (
+	frameHeaderSize = 12
+	maxFrameBytes   = 1 << 30
+)
+
+var (
+	errTornFrame = errors.New("truncated record")
+	errChecksum  = fmt.Errorf("%w: payload checksum mismatch", ErrCorrupt)
+)
+
+func encodeFrame(v any) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(frame[0:4], crcTable))
+	binary.BigEndian.PutUint32(frame[8:12], crc32.Checksum(payload, crcTable))
+	copy(frame[frameHeaderSize:], payload)
+	return frame, nil
+}
+
+func writeFrame(w io.Writer, v any) error {
+	frame, err := encodeFrame(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// readFrame decodes the next frame into v. It returns io.EOF at a clean end
+// of input, errTornFrame if the input ends inside a frame, errChecksum if
+// the payload is damaged and ErrCorrupt for any other damage.
+func readFrame(r io.Reader, v any) error {
+	var header [frameHeaderSize]byte
+	if n, err := io.ReadFull(r, header[:]); err != nil {
+		if n == 0 && errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return errTornFrame
+	}
+	if crc32.Checksum(header[0:4], crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return fmt.Errorf("%w: length checksum mismatch", ErrCorrupt)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxFrameBytes {
+		return fmt.Errorf("%w: record of %d bytes", ErrCorrupt, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return errTornFrame
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[8:12]) {
+		return errChecksum
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return nil
+}
+
+// countingReader tracks the offset of the next frame during replay.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
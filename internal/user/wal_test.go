@@ -0,0 +1,205 @@
+package user
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestWAL(t *testing.T, dir string) *Store {
+	t.Helper()
+	s, err := OpenDurable(dir, Options{}, DurabilityOptions{Fsync: FsyncAlways})
+	if err != nil {
+		t.Fatalf("OpenDurable: %v", err)
+	}
+	return s
+}
+
+// writeTestWAL creates three users, renames the first and deletes the
+// second, then closes the store and returns the path of its only segment.
+func writeTestWAL(t *testing.T, dir string) string {
+	t.Helper()
+	s := openTestWAL(t, dir)
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		if _, err := s.Create(Attributes{Name: "User", Email: email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Update("1", Attributes{Name: "Renamed", Email: "a@example.com"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("2", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, walName(0))
+}
+
+// frameOffsets returns the offset of every frame in the segment at path.
+func frameOffsets(t *testing.T, path string) []int64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := &countingReader{r: f}
+	var offsets []int64
+	for {
+		start := r.n
+		var rec walRecord
+		err := readFrame(r, &rec)
+		if errors.Is(err, io.EOF) {
+			return offsets
+		}
+		if err != nil {
+			t.Fatalf("frame at %d: %v", start, err)
+		}
+		offsets = append(offsets, start)
+	}
+}
+
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	writeTestWAL(t, dir)
+
+	s := openTestWAL(t, dir)
+	defer s.Close()
+	u, err := s.Get("1")
+	if err != nil || u.Name != "Renamed" || u.Version != 2 {
+		t.Fatalf("user 1 = %+v, %v; want the renamed version 2", u, err)
+	}
+	if _, err := s.Get("2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("deleted user 2: err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Undelete("2", 0); err != nil {
+		t.Fatalf("undelete replayed soft delete: %v", err)
+	}
+	if u, err := s.Create(Attributes{Name: "User", Email: "d@example.com"}); err != nil || u.ID != "4" {
+		t.Fatalf("create after replay = %q, %v; want ID 4", u.ID, err)
+	}
+}
+
+func TestWALTornTail(t *testing.T) {
+	// Each damage is given the segment, its size and the offset of its last
+	// frame.
+	for name, damage := range map[string]func(path string, size, last int64){
+		"cut payload":     func(path string, size, _ int64) { os.Truncate(path, size-3) },
+		"cut header":      func(path string, _, last int64) { os.Truncate(path, last+frameHeaderSize/2) },
+		"damaged payload": func(path string, size, _ int64) { flipByte(t, path, size-2) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeTestWAL(t, dir)
+			offsets := frameOffsets(t, path)
+			last := offsets[len(offsets)-1]
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			damage(path, info.Size(), last)
+
+			s := openTestWAL(t, dir)
+			defer s.Close()
+			if info, err := os.Stat(path); err != nil || info.Size() != last {
+				t.Fatalf("segment size = %v, %v; want the torn record truncated to %d", info.Size(), err, last)
+			}
+			// The torn record was the soft delete of user 2.
+			if u, err := s.Get("2"); err != nil || u.Deleted() {
+				t.Fatalf("user 2 = %+v, %v; want it live", u, err)
+			}
+		})
+	}
+}
+
+// TestWALTornOlderSegment damages the end of a segment that a newer one
+// follows. Only the newest segment can be torn by a crash, so this must
+// fail rather than drop the record.
+func TestWALTornOlderSegment(t *testing.T) {
+	for name, damage := range map[string]func(path string, size, last int64){
+		"cut payload":     func(path string, size, _ int64) { os.Truncate(path, size-3) },
+		"cut header":      func(path string, _, last int64) { os.Truncate(path, last+frameHeaderSize/2) },
+		"damaged payload": func(path string, size, _ int64) { flipByte(t, path, size-2) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeTestWAL(t, dir)
+			offsets := frameOffsets(t, path)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The newer segment repeats the first record, which replays
+			// harmlessly on top of the older one.
+			if err := os.WriteFile(filepath.Join(dir, walName(1)), data[:offsets[1]], 0o644); err != nil {
+				t.Fatal(err)
+			}
+			damage(path, int64(len(data)), offsets[len(offsets)-1])
+			before, _ := os.Stat(path)
+
+			_, err = OpenDurable(dir, Options{}, DurabilityOptions{Fsync: FsyncAlways})
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("OpenDurable: err = %v, want ErrCorrupt", err)
+			}
+			if after, _ := os.Stat(path); after.Size() != before.Size() {
+				t.Fatalf("segment size changed from %d to %d", before.Size(), after.Size())
+			}
+		})
+	}
+}
+
+func TestWALCorruption(t *testing.T) {
+	for name, offset := range map[string]int64{
+		"length":         0,
+		"length crc":     5,
+		"payload crc":    9,
+		"payload":        frameHeaderSize + 2,
+		"unknown record": -1,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := writeTestWAL(t, dir)
+			if offset < 0 {
+				// A well-formed frame that does not decode to a record.
+				frame, err := encodeFrame(walRecord{Op: "bogus"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, append(frame, data...), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				flipByte(t, path, offset)
+			}
+			before, _ := os.Stat(path)
+
+			_, err := OpenDurable(dir, Options{}, DurabilityOptions{Fsync: FsyncAlways})
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("OpenDurable: err = %v, want ErrCorrupt", err)
+			}
+			if after, _ := os.Stat(path); after.Size() != before.Size() {
+				t.Fatalf("segment size changed from %d to %d", before.Size(), after.Size())
+			}
+		})
+	}
+}